@@ -0,0 +1,113 @@
+package memdb
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+func (m *MemDB) hasAutoSnapshot() bool {
+	return m.autoSnapInterval > 0 || m.autoSnapDeletes > 0
+}
+
+func (m *MemDB) startAutoSnapshot() {
+	m.autoSnapStop = make(chan struct{})
+	m.autoSnapTrigger = make(chan struct{}, 1)
+	m.autoSnapWg.Add(1)
+	go m.autoSnapshotWorker()
+}
+
+// The worker may be started by Subscribe
+func (m *MemDB) stopAutoSnapshot() {
+	m.wlock.Lock()
+	stop := m.autoSnapStop
+	m.wlock.Unlock()
+
+	if stop != nil {
+		close(stop)
+		m.autoSnapWg.Wait()
+	}
+}
+
+// Called by a writer with w.mu held, once its pending deletes reach the
+// configured limit or its captured mutations fill up half of the buffer
+func (m *MemDB) triggerAutoSnapshot() {
+	select {
+	case m.autoSnapTrigger <- struct{}{}:
+	default:
+	}
+}
+
+// Auto snapshots are skipped while the DB is being restored from disk,
+// since the store is replaced once the restore is done.
+func (m *MemDB) pauseAutoSnapshot() {
+	m.autoSnapMu.Lock()
+	m.autoSnapPaused++
+	m.autoSnapMu.Unlock()
+}
+
+func (m *MemDB) resumeAutoSnapshot() {
+	m.autoSnapMu.Lock()
+	m.autoSnapPaused--
+	m.autoSnapMu.Unlock()
+}
+
+func (m *MemDB) autoSnapshotWorker() {
+	defer m.autoSnapWg.Done()
+
+	var tick <-chan time.Time
+	if m.autoSnapInterval > 0 {
+		ticker := time.NewTicker(m.autoSnapInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-m.autoSnapStop:
+			return
+		case <-tick:
+			// A snapshot created by the user within the interval
+			// already handed over the pending deletes
+			last := time.Unix(0, atomic.LoadInt64(&m.lastSnapTime))
+			if time.Since(last) >= m.autoSnapInterval {
+				m.autoSnapshot()
+			}
+		case <-m.autoSnapTrigger:
+			m.autoSnapshot()
+		}
+	}
+}
+
+func (m *MemDB) autoSnapshot() {
+	m.autoSnapMu.Lock()
+	defer m.autoSnapMu.Unlock()
+
+	if m.autoSnapPaused > 0 || !m.hasPendingChanges() {
+		return
+	}
+
+	if snap, err := m.NewSnapshot(); err == nil {
+		snap.Close()
+	}
+}
+
+// Pending deletes or mutations not yet published to subscribers
+func (m *MemDB) hasPendingChanges() bool {
+	m.wlock.Lock()
+	defer m.wlock.Unlock()
+
+	if m.closedGCHead != nil || len(m.closedMuts) > 0 || m.closedOverflow {
+		return true
+	}
+
+	for w := m.wlist; w != nil; w = w.next {
+		w.mu.Lock()
+		pending := w.gchead != nil || len(w.mutations) > 0 || w.overflow
+		w.mu.Unlock()
+		if pending {
+			return true
+		}
+	}
+
+	return false
+}
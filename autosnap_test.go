@@ -0,0 +1,147 @@
+package memdb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAutoSnapshotInterval(t *testing.T) {
+	conf := DefaultConfig()
+	conf.SetAutoSnapshot(50*time.Millisecond, 0)
+	db := NewWithConfig(conf)
+	defer db.Close()
+
+	n := 1000
+	w := db.NewWriter()
+	for i := 0; i < n; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	snap, _ := db.NewSnapshot()
+	snap.Close()
+
+	sn := db.getCurrSn()
+	for i := 0; i < n; i++ {
+		w.Delete([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.WaitForGC(ctx, sn); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if c := db.store.GetStats().NodeCount; c != 0 {
+		t.Errorf("Expected all items to be reclaimed, got %d", c)
+	}
+
+	if db.ItemsCount() != 0 {
+		t.Errorf("Expected items count 0, got %d", db.ItemsCount())
+	}
+}
+
+func TestAutoSnapshotPendingDeletes(t *testing.T) {
+	conf := DefaultConfig()
+	conf.SetAutoSnapshot(0, 100)
+	db := NewWithConfig(conf)
+	defer db.Close()
+
+	n := 1000
+	w := db.NewWriter()
+	for i := 0; i < n; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap, _ := db.NewSnapshot()
+	snap.Close()
+
+	// Deletes below the limit are left pending
+	sn := db.getCurrSn()
+	for i := 0; i < 10; i++ {
+		w.Delete([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := db.WaitForGC(ctx, sn); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+
+	for i := 10; i < n; i++ {
+		w.Delete([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.WaitForGC(ctx, sn); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestAutoSnapshotWithUserSnapshots(t *testing.T) {
+	conf := testConf
+	conf.SetAutoSnapshot(time.Millisecond, 10)
+	db := NewWithConfig(conf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 10000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+		if i%2 == 0 {
+			w.Delete([]byte(fmt.Sprintf("%010d", i)))
+		}
+
+		if i%1000 == 0 {
+			snap, _ := w.NewSnapshot()
+			VerifyCount(snap, i/2, t)
+			snap.Close()
+		}
+	}
+
+	snap, _ := w.NewSnapshot()
+	defer snap.Close()
+	VerifyCount(snap, 5000, t)
+}
+
+func TestAutoSnapshotDuringStoreToDisk(t *testing.T) {
+	os.RemoveAll("db.dump")
+	defer os.RemoveAll("db.dump")
+
+	conf := DefaultConfig()
+	conf.SetAutoSnapshot(10*time.Millisecond, 0)
+	db := NewWithConfig(conf)
+	defer db.Close()
+
+	sub, _ := db.Subscribe(SubscribeOptions{BufferSize: 10})
+	defer sub.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 100; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap, _ := w.NewSnapshot()
+
+	// Mutations are published while the snapshot is being written
+	var once sync.Once
+	published := false
+	callb := func(*ItemEntry) {
+		once.Do(func() {
+			w.Put([]byte(fmt.Sprintf("%010d", 100)))
+			for i := 0; i < 500 && !published; i++ {
+				published = sub.Lag() > 0
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+
+	if err := db.StoreToDisk("db.dump", snap, 4, callb); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	if !published {
+		t.Errorf("Expected mutations to be published during StoreToDisk")
+	}
+}
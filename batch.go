@@ -0,0 +1,103 @@
+package memdb
+
+type batchOpType int
+
+const (
+	batchPut batchOpType = iota
+	batchDelete
+	batchUpdate
+	batchUpsert
+)
+
+type batchOp struct {
+	typ batchOpType
+	key []byte
+	val []byte
+	old []byte
+}
+
+// Batch is a list of mutations which are applied atomically by a writer.
+// The byte slices added to a batch should not be modified until the batch
+// has been applied.
+type Batch struct {
+	ops []batchOp
+}
+
+func NewBatch() *Batch {
+	return &Batch{}
+}
+
+func (b *Batch) Put(bs []byte) {
+	b.ops = append(b.ops, batchOp{typ: batchPut, key: bs})
+}
+
+func (b *Batch) PutKV(key, val []byte) {
+	b.ops = append(b.ops, batchOp{typ: batchPut, key: key, val: val})
+}
+
+func (b *Batch) Delete(bs []byte) {
+	b.ops = append(b.ops, batchOp{typ: batchDelete, key: bs})
+}
+
+func (b *Batch) Update(old, new []byte) {
+	b.ops = append(b.ops, batchOp{typ: batchUpdate, key: new, old: old})
+}
+
+func (b *Batch) UpdateKV(key, val []byte) {
+	b.ops = append(b.ops, batchOp{typ: batchUpdate, key: key, val: val})
+}
+
+func (b *Batch) Upsert(bs []byte) {
+	b.ops = append(b.ops, batchOp{typ: batchUpsert, key: bs})
+}
+
+func (b *Batch) UpsertKV(key, val []byte) {
+	b.ops = append(b.ops, batchOp{typ: batchUpsert, key: key, val: val})
+}
+
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+func (b *Batch) Reset() {
+	b.ops = b.ops[:0]
+}
+
+// Apply performs all the mutations in the batch under the same sequence
+// number. Snapshot creation waits for an in-flight batch, hence a snapshot
+// observes either all or none of the mutations. The result of each
+// operation is returned in the order it was added to the batch. The whole
+// batch fails if it is rejected by the memory quota.
+func (w *Writer) Apply(b *Batch) []bool {
+	results := make([]bool, len(b.ops))
+	if w.checkQuota() != nil {
+		return results
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.apply(b, results)
+}
+
+// Called with w.mu held
+func (w *Writer) apply(b *Batch, results []bool) []bool {
+	sn := w.getCurrSn()
+	for i, op := range b.ops {
+		switch op.typ {
+		case batchPut:
+			results[i] = w.put(op.key, op.val, sn) != nil
+		case batchDelete:
+			_, results[i] = w.delete(op.key, sn)
+		case batchUpdate:
+			if op.old != nil && w.keyCmp(op.old, op.key) != 0 {
+				continue
+			}
+			_, results[i] = w.replace(w.newItem(op.key, op.val, w.useMemoryMgmt), sn, false, nil)
+		case batchUpsert:
+			_, results[i] = w.replace(w.newItem(op.key, op.val, w.useMemoryMgmt), sn, true, nil)
+		}
+	}
+
+	return results
+}
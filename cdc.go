@@ -0,0 +1,199 @@
+package memdb
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+var ErrSubscriptionOverflow = fmt.Errorf("Subscription buffer is full")
+
+// Maximum number of mutations buffered by a writer until the next snapshot.
+// A snapshot is requested once half of it is used.
+const captureBufferSize = 1 << 16
+
+// Number of mutations buffered for a subscriber if not configured
+const defaultSubscriptionBufferSize = 1024
+
+type MutationOp int
+
+const (
+	MutationInsert MutationOp = iota
+	MutationDelete
+	MutationUpdate
+)
+
+func (op MutationOp) String() string {
+	switch op {
+	case MutationInsert:
+		return "insert"
+	case MutationDelete:
+		return "delete"
+	case MutationUpdate:
+		return "update"
+	}
+
+	return "unknown"
+}
+
+// Mutation is a committed write. Value is nil for deletes.
+type Mutation struct {
+	Op    MutationOp
+	Key   []byte
+	Value []byte
+	Sn    uint64
+}
+
+type SubscribeOptions struct {
+	// Number of mutations buffered for the subscriber. Defaults to
+	// defaultSubscriptionBufferSize if not positive.
+	BufferSize int
+}
+
+// Subscription delivers the mutations of a MemDB instance on C, ordered by
+// sequence number. Mutations of a sequence number are published when the
+// snapshot of that sequence number is created, hence delivery depends on
+// snapshots being created by the user or by SetAutoSnapshot. A writer which
+// buffers many mutations requests a snapshot by itself, but if it still
+// runs out of buffer space, the mutations are dropped. Publishing never
+// blocks writers. If the buffer of a subscriber is full or mutations were
+// dropped, the subscription is terminated with ErrSubscriptionOverflow and
+// C is closed.
+type Subscription struct {
+	C <-chan Mutation
+
+	ch      chan Mutation
+	db      *MemDB
+	startSn uint64
+	err     error
+	closed  bool
+}
+
+// Subscribe starts a subscription from the sequence number following the
+// current one. Mutations which belong to the current sequence number are
+// not delivered.
+func (m *MemDB) Subscribe(opts SubscribeOptions) (*Subscription, error) {
+	bufSize := opts.BufferSize
+	if bufSize <= 0 {
+		bufSize = defaultSubscriptionBufferSize
+	}
+
+	ch := make(chan Mutation, bufSize)
+	s := &Subscription{C: ch, ch: ch, db: m}
+
+	m.wlock.Lock()
+	defer m.wlock.Unlock()
+
+	// Close sets hasShutdown under wlock before terminating subscriptions
+	if m.hasShutdown {
+		return nil, ErrShutdown
+	}
+
+	// Needed to publish the mutations of writers running out of buffer
+	if m.autoSnapStop == nil {
+		m.startAutoSnapshot()
+	}
+
+	s.startSn = m.getCurrSn() + 1
+	m.subs = append(m.subs, s)
+	atomic.AddInt32(&m.numSubs, 1)
+
+	return s, nil
+}
+
+// Lag returns the number of mutations published but not received yet
+func (s *Subscription) Lag() int {
+	return len(s.ch)
+}
+
+// Err returns the reason for which the subscription was terminated
+func (s *Subscription) Err() error {
+	s.db.wlock.Lock()
+	defer s.db.wlock.Unlock()
+
+	return s.err
+}
+
+func (s *Subscription) Close() {
+	s.db.wlock.Lock()
+	defer s.db.wlock.Unlock()
+
+	s.db.unsubscribe(s, nil)
+}
+
+// Called with wlock held
+func (m *MemDB) unsubscribe(s *Subscription, err error) {
+	if s.closed {
+		return
+	}
+
+	for i, x := range m.subs {
+		if x == s {
+			m.subs = append(m.subs[:i], m.subs[i+1:]...)
+			break
+		}
+	}
+
+	s.closed = true
+	s.err = err
+	close(s.ch)
+	atomic.AddInt32(&m.numSubs, -1)
+}
+
+// Record a successful write if there are subscribers. Called with the
+// writer mutex held.
+func (w *Writer) capture(op MutationOp, key, val []byte) {
+	if atomic.LoadInt32(&w.numSubs) == 0 || w.overflow {
+		return
+	}
+
+	if len(w.mutations) == captureBufferSize {
+		w.mutations = nil
+		w.overflow = true
+		return
+	}
+
+	mut := Mutation{Op: op, Key: append([]byte(nil), key...)}
+	if val != nil {
+		mut.Value = append([]byte(nil), val...)
+	}
+
+	w.mutations = append(w.mutations, mut)
+	if len(w.mutations) == captureBufferSize/2 {
+		w.triggerAutoSnapshot()
+	}
+}
+
+// Called with wlock held while creating the snapshot of sn. Subscriptions
+// are terminated if the writers dropped mutations.
+func (m *MemDB) publish(sn uint64, muts []Mutation, overflow bool) {
+	if len(muts) == 0 && !overflow {
+		return
+	}
+
+	for i := range muts {
+		muts[i].Sn = sn
+	}
+
+	for _, s := range append([]*Subscription(nil), m.subs...) {
+		if sn < s.startSn {
+			continue
+		}
+
+		if overflow {
+			m.unsubscribe(s, ErrSubscriptionOverflow)
+			continue
+		}
+
+		for _, mut := range muts {
+			select {
+			case s.ch <- mut:
+			default:
+				m.unsubscribe(s, ErrSubscriptionOverflow)
+			}
+
+			if s.closed {
+				break
+			}
+		}
+	}
+}
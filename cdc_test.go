@@ -0,0 +1,195 @@
+package memdb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	w.Put([]byte("partial"))
+
+	sub, _ := db.Subscribe(SubscribeOptions{BufferSize: 100})
+	defer sub.Close()
+
+	// Mutations of the sn in progress are not delivered
+	w.Put([]byte("partial2"))
+	snap, _ := w.NewSnapshot()
+	snap.Close()
+
+	for i := 0; i < 10; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap1, _ := w.NewSnapshot()
+	defer snap1.Close()
+
+	b := NewBatch()
+	for i := 0; i < 5; i++ {
+		b.Delete([]byte(fmt.Sprintf("%010d", i)))
+		b.UpsertKV([]byte(fmt.Sprintf("%010d", i+5)), []byte("v2"))
+	}
+	w.Apply(b)
+
+	if sub.Lag() != 10 {
+		t.Errorf("Expected lag 10, got %d", sub.Lag())
+	}
+
+	snap2, _ := w.NewSnapshot()
+	defer snap2.Close()
+
+	if sub.Lag() != 20 {
+		t.Errorf("Expected lag 20, got %d", sub.Lag())
+	}
+
+	for i := 0; i < 10; i++ {
+		m := <-sub.C
+		key := fmt.Sprintf("%010d", i)
+		if m.Op != MutationInsert || string(m.Key) != key || m.Sn != snap1.sn {
+			t.Errorf("Unexpected mutation %v %s@%d", m.Op, m.Key, m.Sn)
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		m := <-sub.C
+		key := fmt.Sprintf("%010d", i)
+		if m.Op != MutationDelete || string(m.Key) != key || m.Sn != snap2.sn {
+			t.Errorf("Unexpected mutation %v %s@%d", m.Op, m.Key, m.Sn)
+		}
+
+		m = <-sub.C
+		key = fmt.Sprintf("%010d", i+5)
+		if m.Op != MutationUpdate || string(m.Key) != key || string(m.Value) != "v2" || m.Sn != snap2.sn {
+			t.Errorf("Unexpected mutation %v %s=%s@%d", m.Op, m.Key, m.Value, m.Sn)
+		}
+	}
+
+	if sub.Lag() != 0 {
+		t.Errorf("Expected no lag, got %d", sub.Lag())
+	}
+}
+
+func TestSubscribeOverflow(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	sub, _ := db.Subscribe(SubscribeOptions{BufferSize: 5})
+	w1 := db.NewWriter()
+	w2 := db.NewWriter()
+	snap, _ := w2.NewSnapshot()
+	snap.Close()
+
+	for i := 0; i < 10; i++ {
+		w1.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	// Mutations of a closed writer are handed over to the next snapshot
+	w1.Close()
+	snap, _ = w2.NewSnapshot()
+	snap.Close()
+
+	n := 0
+	for range sub.C {
+		n++
+	}
+
+	if n != 5 {
+		t.Errorf("Expected 5 mutations, got %d", n)
+	}
+
+	if err := sub.Err(); err != ErrSubscriptionOverflow {
+		t.Errorf("Expected overflow, got %v", err)
+	}
+
+	sub.Close()
+}
+
+func TestSubscribeCaptureBuffer(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	sub, _ := db.Subscribe(SubscribeOptions{BufferSize: captureBufferSize})
+	w := db.NewWriter()
+	snap, _ := w.NewSnapshot()
+	snap.Close()
+
+	// A writer with a half full buffer gets its mutations published
+	// without any snapshot from the user
+	for i := 0; i < captureBufferSize/2; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	timeout := time.After(10 * time.Second)
+	for i := 0; i < captureBufferSize/2; i++ {
+		select {
+		case m := <-sub.C:
+			if key := fmt.Sprintf("%010d", i); string(m.Key) != key {
+				t.Fatalf("Expected %s, got %s", key, m.Key)
+			}
+		case <-timeout:
+			t.Fatalf("Expected mutations to be published, got %d", i)
+		}
+	}
+
+	// Mutations beyond the buffer are dropped and the subscription is
+	// terminated at the next snapshot
+	db.pauseAutoSnapshot()
+	for i := 0; i <= captureBufferSize; i++ {
+		w.Delete([]byte(fmt.Sprintf("%010d", i)))
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	db.resumeAutoSnapshot()
+
+	if sts := db.Stats(); sts.Writers[0].PendingMutations != 0 {
+		t.Errorf("Expected mutations to be dropped, got %d", sts.Writers[0].PendingMutations)
+	}
+
+	snap, _ = w.NewSnapshot()
+	snap.Close()
+
+	for range sub.C {
+	}
+
+	if err := sub.Err(); err != ErrSubscriptionOverflow {
+		t.Errorf("Expected overflow, got %v", err)
+	}
+}
+
+func TestSubscribeDefaultBufferSize(t *testing.T) {
+	db := NewWithConfig(testConf)
+
+	sub, err := db.Subscribe(SubscribeOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	w := db.NewWriter()
+	snap, _ := w.NewSnapshot()
+	snap.Close()
+
+	for i := 0; i < 10; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap, _ = w.NewSnapshot()
+	snap.Close()
+
+	if sub.Lag() != 10 {
+		t.Errorf("Expected lag 10, got %d", sub.Lag())
+	}
+
+	if err := sub.Err(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	db.Close()
+	if err := sub.Err(); err != ErrShutdown {
+		t.Errorf("Expected shutdown, got %v", err)
+	}
+
+	if _, err := db.Subscribe(SubscribeOptions{}); err != ErrShutdown {
+		t.Errorf("Expected shutdown, got %v", err)
+	}
+}
@@ -0,0 +1,197 @@
+package memdb
+
+import (
+	"fmt"
+	"github.com/t3rm1n4l/memdb/skiplist"
+	"unsafe"
+)
+
+var ErrDiffOrder = fmt.Errorf("Diff from snapshot is newer than the to snapshot")
+
+type DiffOp int
+
+const (
+	DiffInsert DiffOp = iota
+	DiffDelete
+	DiffUpdate
+)
+
+func (op DiffOp) String() string {
+	switch op {
+	case DiffInsert:
+		return "insert"
+	case DiffDelete:
+		return "delete"
+	case DiffUpdate:
+		return "update"
+	}
+
+	return "unknown"
+}
+
+// DiffIterator iterates over the keys which differ between two snapshots
+// in key order. A key is reported as inserted if it is only visible in the
+// to snapshot, deleted if it is only visible in the from snapshot and
+// updated if it was replaced by a different version.
+//
+// The iterator scans all the items of the store. The gclists of the
+// snapshots in between only track the items which died, inserts are not
+// tracked anywhere and finding them by bornSn needs a full scan anyway.
+// The scan is refreshed as per the configured refresh rate so that it does
+// not hold off the reclamation of the items freed in the meantime.
+type DiffIterator struct {
+	count       int
+	refreshRate int
+
+	from, to *Snapshot
+	iter     *skiplist.Iterator
+	buf      *skiplist.ActionBuffer
+
+	op       DiffOp
+	old, new *Item
+	valid    bool
+}
+
+// NewDiffIterator returns ErrInvalidSnapshot if either of the snapshots
+// is nil or already closed and ErrDiffOrder if from is newer than to
+func (m *MemDB) NewDiffIterator(from, to *Snapshot) (*DiffIterator, error) {
+	if from == nil || to == nil {
+		return nil, ErrInvalidSnapshot
+	}
+
+	if from.sn > to.sn {
+		return nil, ErrDiffOrder
+	}
+
+	if !from.Open() {
+		return nil, ErrInvalidSnapshot
+	}
+
+	if !to.Open() {
+		from.Close()
+		return nil, ErrInvalidSnapshot
+	}
+
+	buf := m.store.MakeBuf()
+	return &DiffIterator{
+		refreshRate: m.refreshRate,
+		from:        from,
+		to:          to,
+		iter:        m.store.NewIterator(m.insCmp, buf),
+		buf:         buf,
+	}, nil
+}
+
+func (it *DiffIterator) SeekFirst() {
+	it.iter.SeekFirst()
+	it.findNext()
+}
+
+func (it *DiffIterator) Next() {
+	it.findNext()
+}
+
+// All the versions of a key are adjacent. Find the version visible in each
+// snapshot and stop at the first key where they differ.
+func (it *DiffIterator) findNext() {
+	db := it.to.db
+	for it.iter.Valid() {
+		if it.refreshRate > 0 && it.count > it.refreshRate {
+			it.refresh()
+			it.count = 0
+			continue
+		}
+
+		var old, new *Item
+		key := (*Item)(it.iter.Get()).Bytes()
+		for ; it.iter.Valid(); it.iter.Next() {
+			it.count++
+			itm := (*Item)(it.iter.Get())
+			if db.keyCmp(itm.Bytes(), key) != 0 {
+				break
+			}
+
+			if itm.isVisible(it.from) {
+				old = itm
+			}
+
+			if itm.isVisible(it.to) {
+				new = itm
+			}
+		}
+
+		if old != new {
+			it.old, it.new = old, new
+			switch {
+			case old == nil:
+				it.op = DiffInsert
+			case new == nil:
+				it.op = DiffDelete
+			default:
+				it.op = DiffUpdate
+			}
+
+			it.valid = true
+			return
+		}
+	}
+
+	it.valid = false
+}
+
+// Restarts the scan from the current item with a new barrier session.
+// Items which are freed in the meantime are not visible in either snapshot,
+// hence the scan may resume from a later version of the current key.
+func (it *DiffIterator) refresh() {
+	db := it.to.db
+	itm := db.ptrToItem(it.iter.Get())
+	it.iter.Close()
+	it.iter = db.store.NewIterator(db.insCmp, it.buf)
+	it.iter.Seek(unsafe.Pointer(itm))
+}
+
+func (it *DiffIterator) SetRefreshRate(rate int) {
+	it.refreshRate = rate
+}
+
+func (it *DiffIterator) Valid() bool {
+	return it.valid && !it.to.db.isAborted()
+}
+
+func (it *DiffIterator) Op() DiffOp {
+	return it.op
+}
+
+func (it *DiffIterator) Key() []byte {
+	if it.new != nil {
+		return it.new.Bytes()
+	}
+
+	return it.old.Bytes()
+}
+
+// Value returns the value in the to snapshot. Deleted keys return the value
+// from the from snapshot.
+func (it *DiffIterator) Value() []byte {
+	if it.new != nil {
+		return it.new.Value()
+	}
+
+	return it.old.Value()
+}
+
+// OldValue returns the value in the from snapshot or nil for inserted keys
+func (it *DiffIterator) OldValue() []byte {
+	if it.old != nil {
+		return it.old.Value()
+	}
+
+	return nil
+}
+
+func (it *DiffIterator) Close() {
+	it.from.Close()
+	it.to.Close()
+	it.to.db.store.FreeBuf(it.buf)
+	it.iter.Close()
+}
@@ -0,0 +1,94 @@
+package memdb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDiffIterator(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 100; i++ {
+		w.PutKV([]byte(fmt.Sprintf("%010d", i)), []byte("v1"))
+	}
+	snap1, _ := w.NewSnapshot()
+	defer snap1.Close()
+
+	for i := 0; i < 10; i++ {
+		w.Delete([]byte(fmt.Sprintf("%010d", i)))
+		w.UpsertKV([]byte(fmt.Sprintf("%010d", i+10)), []byte("v2"))
+		w.PutKV([]byte(fmt.Sprintf("%010d", i+100)), []byte("v2"))
+	}
+
+	// Keys which are inserted and deleted in between are not reported
+	w.Put([]byte("transient"))
+	snap2, _ := w.NewSnapshot()
+	defer snap2.Close()
+	w.Delete([]byte("transient"))
+	snap3, _ := w.NewSnapshot()
+	defer snap3.Close()
+
+	var keys []int
+	for i := 0; i < 20; i++ {
+		keys = append(keys, i)
+	}
+	for i := 100; i < 110; i++ {
+		keys = append(keys, i)
+	}
+
+	verify := func(rate int) {
+		i := 0
+		itr, err := db.NewDiffIterator(snap1, snap3)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		itr.SetRefreshRate(rate)
+		for itr.SeekFirst(); itr.Valid(); itr.Next() {
+			if i == len(keys) {
+				t.Fatalf("Unexpected change %s", itr.Key())
+			}
+
+			k := keys[i]
+			op := DiffInsert
+			if k < 10 {
+				op = DiffDelete
+			} else if k < 20 {
+				op = DiffUpdate
+			}
+
+			key := fmt.Sprintf("%010d", k)
+			if string(itr.Key()) != key || itr.Op() != op {
+				t.Errorf("Expected %v %s, got %v %s", op, key, itr.Op(), itr.Key())
+			}
+
+			if op == DiffUpdate && (string(itr.OldValue()) != "v1" || string(itr.Value()) != "v2") {
+				t.Errorf("Unexpected values %s -> %s for %s", itr.OldValue(), itr.Value(), key)
+			}
+			i++
+		}
+		itr.Close()
+
+		if i != len(keys) {
+			t.Errorf("Expected %d changes, got %d", len(keys), i)
+		}
+	}
+
+	verify(0)
+	verify(3)
+
+	if _, err := db.NewDiffIterator(snap3, snap1); err != ErrDiffOrder {
+		t.Errorf("Expected ErrDiffOrder, got %v", err)
+	}
+
+	snap4, _ := w.NewSnapshot()
+	snap4.Close()
+	if _, err := db.NewDiffIterator(snap1, snap4); err != ErrInvalidSnapshot {
+		t.Errorf("Expected ErrInvalidSnapshot, got %v", err)
+	}
+
+	if _, err := db.NewDiffIterator(nil, snap1); err != ErrInvalidSnapshot {
+		t.Errorf("Expected ErrInvalidSnapshot, got %v", err)
+	}
+}
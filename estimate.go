@@ -0,0 +1,164 @@
+package memdb
+
+import (
+	"math"
+	"unsafe"
+)
+
+// Minimum number of sampled items for an estimate. Sampling moves down to
+// denser skiplist levels until the range yields enough samples.
+const estimateMinSamples = 256
+
+// Estimate is an approximate value with an error bound of about two
+// standard deviations. Exact is set when the value was computed from all
+// the items.
+type Estimate struct {
+	Value int64
+	Error int64
+	Exact bool
+}
+
+// EstimateCount estimates the number of items in the range [start, end)
+// visible in the snapshot. A nil start or end leaves that side of the
+// range unbounded. If exact is set, all the items in the range are counted.
+func (s *Snapshot) EstimateCount(start, end []byte, exact bool) Estimate {
+	count, _ := s.estimate(start, end, exact)
+	return count
+}
+
+// EstimateBytes estimates the total key and value size of the items in
+// the range [start, end) visible in the snapshot
+func (s *Snapshot) EstimateBytes(start, end []byte, exact bool) Estimate {
+	_, bytes := s.estimate(start, end, exact)
+	return bytes
+}
+
+func (s *Snapshot) estimate(start, end []byte, exact bool) (count, bytes Estimate) {
+	level := s.db.store.Level()
+	if exact {
+		level = 0
+	}
+
+	for ; level >= 0; level-- {
+		var n, sz int64
+		s.sampleRange(start, end, level, func(itm *Item) {
+			n++
+			sz += int64(len(itm.Bytes()) + len(itm.Value()))
+		})
+
+		if n >= estimateMinSamples || level == 0 {
+			scale := s.db.sampleScale(level)
+			count.Value, bytes.Value = n*scale, sz*scale
+			if level == 0 {
+				count.Exact, bytes.Exact = true, true
+			} else {
+				count.Error = int64(2 * math.Sqrt(float64(n)) * float64(scale))
+				bytes.Error = count.Error * sz / n
+			}
+			return
+		}
+	}
+
+	return
+}
+
+// Each node is present at a level with probability p^level, where p is
+// the level probability of the skiplist. A sampled node stands for 1/p^level
+// nodes.
+func (m *MemDB) sampleScale(level int) int64 {
+	return int64(math.Pow(1/m.store.LevelProbability(), float64(level)))
+}
+
+// Calls callb for the sampled items at the level which are visible in the
+// snapshot and belong to the range [start, end)
+func (s *Snapshot) sampleRange(start, end []byte, level int, callb func(*Item)) {
+	var startItm unsafe.Pointer
+	if start != nil {
+		startItm = unsafe.Pointer(s.db.newItem(start, nil, false))
+	}
+
+	buf := s.db.store.MakeBuf()
+	defer s.db.store.FreeBuf(buf)
+
+	s.db.store.VisitLevel(startItm, s.db.insCmp, level, buf,
+		func(p unsafe.Pointer) bool {
+			itm := (*Item)(p)
+			if end != nil && s.db.keyCmp(itm.Bytes(), end) >= 0 {
+				return false
+			}
+
+			if itm.isVisible(s) {
+				callb(itm)
+			}
+			return true
+		})
+}
+
+// Average number of samples per histogram bucket
+const histogramBucketSamples = 64
+
+// HistogramBucket covers the keys in the range [Start, End). A nil Start
+// or End denotes the start or end of the key space.
+type HistogramBucket struct {
+	Start []byte
+	End   []byte
+	Count int64
+	Bytes int64
+}
+
+// KeyHistogram splits the keys visible in the snapshot into the given
+// number of buckets holding approximately the same number of items. The
+// counts and sizes are estimated from a sample of the skiplist. Fewer
+// buckets are returned if there are not enough items. It returns nil if
+// the snapshot is already closed.
+func (m *MemDB) KeyHistogram(snap *Snapshot, buckets int) []HistogramBucket {
+	if buckets < 1 || !snap.Open() {
+		return nil
+	}
+	defer snap.Close()
+
+	type sample struct {
+		key  []byte
+		size int64
+	}
+
+	var samples []sample
+	var scale int64
+	for level := m.store.Level(); level >= 0; level-- {
+		samples = samples[:0]
+		snap.sampleRange(nil, nil, level, func(itm *Item) {
+			samples = append(samples, sample{
+				key:  append([]byte(nil), itm.Bytes()...),
+				size: int64(len(itm.Bytes()) + len(itm.Value())),
+			})
+		})
+
+		if len(samples) >= buckets*histogramBucketSamples || level == 0 {
+			scale = m.sampleScale(level)
+			break
+		}
+	}
+
+	if len(samples) < buckets {
+		buckets = len(samples)
+	}
+
+	hist := make([]HistogramBucket, buckets)
+	for i := range hist {
+		b := &hist[i]
+		lo, hi := i*len(samples)/buckets, (i+1)*len(samples)/buckets
+		if i > 0 {
+			b.Start = samples[lo].key
+		}
+		if hi < len(samples) {
+			b.End = samples[hi].key
+		}
+
+		for _, s := range samples[lo:hi] {
+			b.Count += scale
+			b.Bytes += s.size * scale
+		}
+	}
+
+	return hist
+}
@@ -0,0 +1,113 @@
+package memdb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestEstimateCount(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	n := 100000
+	w := db.NewWriter()
+	for i := 0; i < n; i++ {
+		w.PutKV([]byte(fmt.Sprintf("%010d", i)), []byte("value"))
+	}
+	snap1, _ := w.NewSnapshot()
+	defer snap1.Close()
+
+	for i := 0; i < n/2; i++ {
+		w.Delete([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap2, _ := w.NewSnapshot()
+	defer snap2.Close()
+
+	check := func(snap *Snapshot, start, end []byte, count int64) {
+		est := snap.EstimateCount(start, end, false)
+		if d := est.Value - count; d > 2*est.Error || d < -2*est.Error {
+			t.Errorf("Estimate %d (+/-%d) too far from %d", est.Value, est.Error, count)
+		}
+
+		bytes := snap.EstimateBytes(start, end, false)
+		if d := bytes.Value - count*15; d > 2*bytes.Error || d < -2*bytes.Error {
+			t.Errorf("Estimate %d (+/-%d) too far from %d", bytes.Value, bytes.Error, count*15)
+		}
+
+		exact := snap.EstimateCount(start, end, true)
+		if !exact.Exact || exact.Value != count || exact.Error != 0 {
+			t.Errorf("Expected exact count %d, got %+v", count, exact)
+		}
+	}
+
+	check(snap1, nil, nil, int64(n))
+	check(snap2, nil, nil, int64(n/2))
+	check(snap1, []byte(fmt.Sprintf("%010d", 20000)), []byte(fmt.Sprintf("%010d", 60000)), 40000)
+	check(snap2, []byte(fmt.Sprintf("%010d", 20000)), []byte(fmt.Sprintf("%010d", 60000)), 10000)
+
+	// Small ranges are counted exactly
+	est := snap1.EstimateCount([]byte(fmt.Sprintf("%010d", 100)), []byte(fmt.Sprintf("%010d", 200)), false)
+	if !est.Exact || est.Value != 100 {
+		t.Errorf("Expected exact count 100, got %+v", est)
+	}
+}
+
+func TestKeyHistogram(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	n := 100000
+	w := db.NewWriter()
+	for i := 0; i < n; i++ {
+		w.PutKV([]byte(fmt.Sprintf("%010d", i)), []byte("value"))
+	}
+	snap, _ := w.NewSnapshot()
+	defer snap.Close()
+
+	// Mutations after the snapshot are not reflected
+	for i := 0; i < n/2; i++ {
+		w.Delete([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	hist := db.KeyHistogram(snap, 8)
+	if len(hist) != 8 {
+		t.Fatalf("Expected 8 buckets, got %d", len(hist))
+	}
+
+	var total, exactTotal int64
+	for i, b := range hist {
+		if i > 0 && string(b.Start) != string(hist[i-1].End) {
+			t.Errorf("Bucket %d does not start at the end of the previous bucket", i)
+		}
+
+		exact := snap.EstimateCount(b.Start, b.End, true)
+		exactTotal += exact.Value
+		if d := b.Count - exact.Value; d > int64(n/20) || d < -int64(n/20) {
+			t.Errorf("Bucket %d count %d too far from %d", i, b.Count, exact.Value)
+		}
+
+		if b.Bytes != b.Count*15 {
+			t.Errorf("Expected bucket bytes %d, got %d", b.Count*15, b.Bytes)
+		}
+		total += b.Count
+	}
+
+	if hist[0].Start != nil || hist[7].End != nil {
+		t.Errorf("Expected first and last buckets to be unbounded")
+	}
+
+	// Buckets cover the whole snapshot
+	if exactTotal != snap.Count() {
+		t.Errorf("Expected bucket counts to sum to %d, got %d", snap.Count(), exactTotal)
+	}
+
+	if d := total - int64(n); d > int64(n/10) || d < -int64(n/10) {
+		t.Errorf("Total count %d too far from %d", total, n)
+	}
+
+	snap2, _ := w.NewSnapshot()
+	snap2.Close()
+	if hist := db.KeyHistogram(snap2, 8); hist != nil {
+		t.Errorf("Expected no histogram for closed snapshot")
+	}
+}
@@ -3,8 +3,8 @@ package memdb
 import "os"
 import "bufio"
 import "errors"
+import "encoding/binary"
 import "github.com/couchbase/goforestdb"
-import "bytes"
 
 const DiskBlockSize = 512 * 1024
 
@@ -115,29 +115,29 @@ type forestdbFileWriter struct {
 	db    *MemDB
 	file  *forestdb.File
 	store *forestdb.KVStore
-	buf   []byte
-	wbuf  bytes.Buffer
+	meta  [4]byte
 }
 
 func (f *forestdbFileWriter) Open(path string) error {
 	var err error
 	f.file, err = forestdb.Open(path, forestdbConfig)
 	if err == nil {
-		f.buf = make([]byte, encodeBufSize)
 		f.store, err = f.file.OpenKVStoreDefault(nil)
 	}
 
 	return err
 }
 
+// Item expiry is stored in the document metadata
 func (f *forestdbFileWriter) WriteItem(itm *Item) error {
-	f.wbuf.Reset()
-	err := f.db.EncodeItem(itm, f.buf, &f.wbuf)
-	if err == nil {
-		err = f.store.SetKV(f.wbuf.Bytes(), nil)
+	binary.BigEndian.PutUint32(f.meta[:], itm.expiry)
+	doc, err := forestdb.NewDoc(itm.Bytes(), f.meta[:], itm.Value())
+	if err != nil {
+		return err
 	}
+	defer doc.Close()
 
-	return err
+	return f.store.Set(doc)
 }
 
 func (f *forestdbFileWriter) Close() error {
@@ -157,7 +157,6 @@ type forestdbFileReader struct {
 	file  *forestdb.File
 	store *forestdb.KVStore
 	iter  *forestdb.Iterator
-	buf   []byte
 }
 
 func (f *forestdbFileReader) Open(path string) error {
@@ -165,7 +164,6 @@ func (f *forestdbFileReader) Open(path string) error {
 
 	f.file, err = forestdb.Open(path, forestdbConfig)
 	if err == nil {
-		f.buf = make([]byte, encodeBufSize)
 		f.store, err = f.file.OpenKVStoreDefault(nil)
 		if err == nil {
 			f.iter, err = f.store.IteratorInit(nil, nil, forestdb.ITR_NONE)
@@ -176,19 +174,23 @@ func (f *forestdbFileReader) Open(path string) error {
 }
 
 func (f *forestdbFileReader) ReadItem() (*Item, error) {
-	itm := &Item{}
 	doc, err := f.iter.Get()
 	if err == forestdb.RESULT_ITERATOR_FAIL {
 		return nil, nil
 	}
 
 	f.iter.Next()
-	if err == nil {
-		rbuf := bytes.NewBuffer(doc.Key())
-		itm, err = f.db.DecodeItem(f.buf, rbuf)
+	if err != nil {
+		return nil, err
+	}
+	defer doc.Close()
+
+	itm := f.db.newItem(doc.Key(), doc.Body(), f.db.useMemoryMgmt)
+	if meta := doc.Meta(); len(meta) >= 4 {
+		itm.expiry = binary.BigEndian.Uint32(meta)
 	}
 
-	return itm, err
+	return itm, nil
 }
 
 func (f *forestdbFileReader) Close() error {
@@ -0,0 +1,242 @@
+package memdb
+
+import (
+	"context"
+	"github.com/t3rm1n4l/memdb/skiplist"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+type gcStage int
+
+const (
+	gcQueued gcStage = iota
+	gcUnlinking
+	gcBarrierWait
+	gcFreeQueued
+	gcFreeing
+	gcDone
+)
+
+// Tracks the gclists of collected snapshots through the stages of garbage
+// collection. A gclist is queued into gcchan, unlinked from the store by a
+// collection worker, waits for its barrier session to terminate and is
+// freed by a free worker. The stages after unlinking apply only to memory
+// managed DBs.
+type gcTracker struct {
+	sync.Mutex
+	lists    map[*skiplist.Node]uint64 // gclist head -> snapshot sn
+	stages   map[uint64]gcStage
+	changed  chan struct{} // Closed and replaced on every progress
+	shutdown bool
+}
+
+func newGCTracker() *gcTracker {
+	return &gcTracker{
+		lists:   make(map[*skiplist.Node]uint64),
+		stages:  make(map[uint64]gcStage),
+		changed: make(chan struct{}),
+	}
+}
+
+func (t *gcTracker) notify() {
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// Called by the gcToken holder for every collected snapshot, in sn order
+func (m *MemDB) gcQueue(sn uint64, gclist *skiplist.Node) {
+	t := m.gcTracker
+	t.Lock()
+	defer t.Unlock()
+
+	if gclist != nil {
+		t.lists[gclist] = sn
+		t.stages[sn] = gcQueued
+	}
+	atomic.StoreUint64(&m.lastGCSn, sn)
+	t.notify()
+}
+
+func (m *MemDB) gcUnlinkStart(gclist *skiplist.Node) {
+	t := m.gcTracker
+	t.Lock()
+	defer t.Unlock()
+
+	if sn, ok := t.lists[gclist]; ok {
+		t.stages[sn] = gcUnlinking
+	}
+}
+
+func (m *MemDB) gcUnlinkDone(gclist *skiplist.Node) {
+	t := m.gcTracker
+	t.Lock()
+	defer t.Unlock()
+
+	if sn, ok := t.lists[gclist]; ok {
+		if m.useMemoryMgmt {
+			t.stages[sn] = gcBarrierWait
+		} else {
+			delete(t.lists, gclist)
+			delete(t.stages, sn)
+		}
+		t.notify()
+	}
+}
+
+func (m *MemDB) gcFreeQueue(gclist *skiplist.Node) {
+	t := m.gcTracker
+	t.Lock()
+	defer t.Unlock()
+
+	if sn, ok := t.lists[gclist]; ok {
+		t.stages[sn] = gcFreeQueued
+	}
+}
+
+// The gclist is forgotten before its nodes are freed, since the memory
+// of the head node may be reused for another gclist.
+func (m *MemDB) gcFreeStart(gclist *skiplist.Node) (uint64, bool) {
+	t := m.gcTracker
+	t.Lock()
+	defer t.Unlock()
+
+	sn, ok := t.lists[gclist]
+	if ok {
+		delete(t.lists, gclist)
+		t.stages[sn] = gcFreeing
+	}
+
+	return sn, ok
+}
+
+func (m *MemDB) gcFreeDone(sn uint64) {
+	t := m.gcTracker
+	t.Lock()
+	defer t.Unlock()
+
+	delete(t.stages, sn)
+	t.notify()
+}
+
+func (m *MemDB) gcShutdown() {
+	t := m.gcTracker
+	t.Lock()
+	defer t.Unlock()
+
+	t.shutdown = true
+	t.notify()
+}
+
+// Highest sn such that every gclist upto it has completed the stages
+// before the given stage. Should be called with the tracker locked.
+func (m *MemDB) gcWatermark(stage gcStage) uint64 {
+	minSn := uint64(math.MaxUint64)
+	for sn, st := range m.gcTracker.stages {
+		if st < stage && sn < minSn {
+			minSn = sn
+		}
+	}
+
+	if minSn == math.MaxUint64 {
+		return atomic.LoadUint64(&m.lastGCSn)
+	}
+
+	return minSn - 1
+}
+
+// GCStats describes the backlog of garbage collection
+type GCStats struct {
+	// Sn of the oldest open snapshot. Closed snapshots newer than it are
+	// blocked from being collected.
+	OldestLiveSn     uint64 `json:"oldest_live_sn"`
+	BlockedSnapshots int64  `json:"blocked_snapshots"`
+
+	// gclists queued for collection workers, including empty ones
+	QueuedLists int `json:"queued_lists"`
+	QueueCap    int `json:"queue_cap"`
+
+	UnlinkingLists   int    `json:"unlinking_lists"`
+	BarrierWaitLists int    `json:"barrier_wait_lists"`
+	PendingSessions  uint64 `json:"pending_sessions"`
+	FreeQueuedLists  int    `json:"free_queued_lists"`
+	FreeingLists     int    `json:"freeing_lists"`
+	UnlinkedUptoSn   uint64 `json:"unlinked_upto_sn"`
+	FreedUptoSn      uint64 `json:"freed_upto_sn"`
+}
+
+func (m *MemDB) GCStats() GCStats {
+	s := GCStats{
+		QueuedLists: len(m.gcchan),
+		QueueCap:    cap(m.gcchan),
+	}
+
+	if sn := m.minLiveSn(); sn != math.MaxUint64 {
+		s.OldestLiveSn = sn
+	}
+
+	m.visitSnapshots(m.gcsnapshots, func(snap *Snapshot) {
+		if snap.sn > s.OldestLiveSn && s.OldestLiveSn != 0 {
+			s.BlockedSnapshots++
+		}
+	})
+
+	t := m.gcTracker
+	t.Lock()
+	for _, st := range t.stages {
+		switch st {
+		case gcUnlinking:
+			s.UnlinkingLists++
+		case gcBarrierWait:
+			s.BarrierWaitLists++
+		case gcFreeQueued:
+			s.FreeQueuedLists++
+		case gcFreeing:
+			s.FreeingLists++
+		}
+	}
+	s.UnlinkedUptoSn = m.gcWatermark(gcBarrierWait)
+	s.FreedUptoSn = m.gcWatermark(gcDone)
+	t.Unlock()
+
+	if m.useMemoryMgmt {
+		s.PendingSessions = m.store.GetAccesBarrier().PendingSessions()
+	}
+
+	return s
+}
+
+// WaitForGC blocks until every item which died at or before the sn has
+// been unlinked from the store, and freed if memory management is enabled.
+// Items are collected only after all the snapshots upto their deletion sn
+// are closed.
+func (m *MemDB) WaitForGC(ctx context.Context, sn uint64) error {
+	stage := gcBarrierWait
+	if m.useMemoryMgmt {
+		stage = gcDone
+	}
+
+	m.GC()
+
+	t := m.gcTracker
+	for {
+		t.Lock()
+		done := m.gcWatermark(stage) >= sn
+		shutdown := t.shutdown
+		ch := t.changed
+		t.Unlock()
+
+		if done {
+			return nil
+		} else if shutdown {
+			return ErrShutdown
+		}
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
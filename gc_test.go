@@ -0,0 +1,81 @@
+package memdb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func testWaitForGC(conf Config, t *testing.T) {
+	db := NewWithConfig(conf)
+	defer db.Close()
+
+	n := 1000
+	w := db.NewWriter()
+	for i := 0; i < n; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	snap1, _ := w.NewSnapshot()
+	for i := 0; i < n; i++ {
+		w.Delete([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	snap2, _ := w.NewSnapshot()
+	snap2.Close()
+
+	if sts := db.GCStats(); sts.OldestLiveSn != snap1.sn || sts.BlockedSnapshots != 1 {
+		t.Errorf("Unexpected gc stats %+v", sts)
+	}
+
+	// Deletes are not collected while snap1 is open
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := db.WaitForGC(ctx, snap2.sn); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+
+	snap1.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.WaitForGC(ctx, snap2.sn); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	sts := db.GCStats()
+	if sts.BlockedSnapshots != 0 || sts.UnlinkedUptoSn < snap2.sn || sts.FreedUptoSn < snap2.sn {
+		t.Errorf("Unexpected gc stats %+v", sts)
+	}
+
+	if c := db.store.GetStats().NodeCount; c != 0 {
+		t.Errorf("Expected all items to be unlinked, got %d", c)
+	}
+}
+
+func TestWaitForGC(t *testing.T) {
+	testWaitForGC(DefaultConfig(), t)
+}
+
+func TestWaitForGCMemoryMgmt(t *testing.T) {
+	testWaitForGC(testConf, t)
+}
+
+func TestWaitForGCShutdown(t *testing.T) {
+	db := NewWithConfig(testConf)
+	w := db.NewWriter()
+	w.Put([]byte("k1"))
+	snap, _ := w.NewSnapshot()
+	sn := snap.sn
+
+	errch := make(chan error)
+	go func() {
+		errch <- db.WaitForGC(context.Background(), sn+1)
+	}()
+
+	snap.Close()
+	db.Close()
+	if err := <-errch; err != ErrShutdown {
+		t.Errorf("Expected ErrShutdown, got %v", err)
+	}
+}
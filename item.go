@@ -4,21 +4,40 @@ import (
 	"encoding/binary"
 	"io"
 	"reflect"
+	"time"
 	"unsafe"
 )
 
 var itemHeaderSize = unsafe.Sizeof(Item{})
 
 type Item struct {
-	bornSn  uint32
-	deadSn  uint32
+	bornSn  uint64
+	deadSn  uint64
 	dataLen uint32
+	valLen  uint32
+	expiry  uint32 // Unix time in seconds, 0 if the item never expires
+	gen     uint32 // Orders the versions of a key created under the same sn
 }
 
-func (m *MemDB) newItem(data []byte, useMM bool) (itm *Item) {
-	l := len(data)
-	itm = m.allocItem(l, useMM)
-	copy(itm.Bytes(), data)
+func (m *MemDB) newItem(key, val []byte, useMM bool) (itm *Item) {
+	itm = m.allocItem(len(key), len(val), useMM)
+	copy(itm.Bytes(), key)
+	copy(itm.Value(), val)
+	return itm
+}
+
+// probeItem builds an item holding only the key in buf, growing buf if
+// required. The item is valid until buf is reused.
+func (m *MemDB) probeItem(key []byte, buf *[]byte) *Item {
+	blockSize := int(itemHeaderSize) + len(key)
+	if cap(*buf) < blockSize {
+		*buf = make([]byte, blockSize)
+	}
+
+	block := (*buf)[:blockSize]
+	itm := (*Item)(unsafe.Pointer(&block[0]))
+	*itm = Item{dataLen: uint32(len(key))}
+	copy(itm.Bytes(), key)
 	return itm
 }
 
@@ -28,54 +47,69 @@ func (m *MemDB) freeItem(itm *Item) {
 	}
 }
 
-func (m *MemDB) allocItem(l int, useMM bool) (itm *Item) {
-	blockSize := itemHeaderSize + uintptr(l)
+func (m *MemDB) allocItem(kl, vl int, useMM bool) (itm *Item) {
+	blockSize := itemHeaderSize + uintptr(kl+vl)
 	if useMM {
 		itm = (*Item)(m.mallocFun(int(blockSize)))
 		itm.deadSn = 0
 		itm.bornSn = 0
+		itm.expiry = 0
+		itm.gen = 0
 	} else {
 		block := make([]byte, blockSize)
 		itm = (*Item)(unsafe.Pointer(&block[0]))
 	}
 
-	itm.dataLen = uint32(l)
+	itm.dataLen = uint32(kl)
+	itm.valLen = uint32(vl)
 	return
 }
 
+// Item encoding format
+// | keylen - 2 bytes | vallen - 4 bytes | expiry - 4 bytes | key | value |
 func (m *MemDB) EncodeItem(itm *Item, buf []byte, w io.Writer) error {
-	l := 2
+	l := 10
 	if len(buf) < l {
 		return ErrNotEnoughSpace
 	}
 
 	binary.BigEndian.PutUint16(buf[0:2], uint16(itm.dataLen))
-	if _, err := w.Write(buf[0:2]); err != nil {
+	binary.BigEndian.PutUint32(buf[2:6], itm.valLen)
+	binary.BigEndian.PutUint32(buf[6:10], itm.expiry)
+	if _, err := w.Write(buf[0:10]); err != nil {
 		return err
 	}
 	if _, err := w.Write(itm.Bytes()); err != nil {
 		return err
 	}
+	if _, err := w.Write(itm.Value()); err != nil {
+		return err
+	}
 
 	return nil
 }
 
 func (m *MemDB) DecodeItem(buf []byte, r io.Reader) (*Item, error) {
-	if _, err := io.ReadFull(r, buf[0:2]); err != nil {
+	if _, err := io.ReadFull(r, buf[0:10]); err != nil {
 		return nil, err
 	}
 
 	l := binary.BigEndian.Uint16(buf[0:2])
+	vl := binary.BigEndian.Uint32(buf[2:6])
 	if l > 0 {
-		itm := m.allocItem(int(l), m.useMemoryMgmt)
-		data := itm.Bytes()
-		_, err := io.ReadFull(r, data)
+		itm := m.allocItem(int(l), int(vl), m.useMemoryMgmt)
+		itm.expiry = binary.BigEndian.Uint32(buf[6:10])
+		if _, err := io.ReadFull(r, itm.Bytes()); err != nil {
+			return itm, err
+		}
+		_, err := io.ReadFull(r, itm.Value())
 		return itm, err
 	}
 
 	return nil, nil
 }
 
+// Bytes returns the key part of the item. Item comparators only see the key.
 func (itm *Item) Bytes() (bs []byte) {
 	l := itm.dataLen
 	dataOffset := uintptr(unsafe.Pointer(itm)) + itemHeaderSize
@@ -87,7 +121,43 @@ func (itm *Item) Bytes() (bs []byte) {
 	return
 }
 
+func (itm *Item) Key() []byte {
+	return itm.Bytes()
+}
+
+func (itm *Item) Value() (bs []byte) {
+	l := itm.valLen
+	dataOffset := uintptr(unsafe.Pointer(itm)) + itemHeaderSize + uintptr(itm.dataLen)
+
+	hdr := (*reflect.SliceHeader)(unsafe.Pointer(&bs))
+	hdr.Data = dataOffset
+	hdr.Len = int(l)
+	hdr.Cap = hdr.Len
+	return
+}
+
+// Expiry is evaluated against the snapshot creation time so that a
+// snapshot always observes the same set of items
+func (itm *Item) isVisible(s *Snapshot) bool {
+	return itm.bornSn <= s.sn && (itm.deadSn == 0 || itm.deadSn > s.sn) &&
+		!itm.hasExpired(s.ts)
+}
+
+func (itm *Item) hasExpired(ts uint32) bool {
+	return itm.expiry != 0 && itm.expiry <= ts
+}
+
+// Expiry returns the time at which the item expires. A zero time is
+// returned if the item does not expire.
+func (itm *Item) Expiry() time.Time {
+	if itm.expiry == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(int64(itm.expiry), 0)
+}
+
 func ItemSize(p unsafe.Pointer) int {
 	itm := (*Item)(p)
-	return int(itemHeaderSize + uintptr(itm.dataLen))
+	return int(itemHeaderSize + uintptr(itm.dataLen) + uintptr(itm.valLen))
 }
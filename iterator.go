@@ -2,9 +2,18 @@ package memdb
 
 import (
 	"github.com/t3rm1n4l/memdb/skiplist"
+	"math"
 	"unsafe"
 )
 
+type RangeOpts int
+
+const (
+	IncludeStart RangeOpts = 1 << iota
+	IncludeEnd
+	IncludeBoth = IncludeStart | IncludeEnd
+)
+
 type Iterator struct {
 	count       int
 	refreshRate int
@@ -12,45 +21,164 @@ type Iterator struct {
 	snap *Snapshot
 	iter *skiplist.Iterator
 	buf  *skiplist.ActionBuffer
+
+	start, end *Item
+	opts       RangeOpts
+	reverse    bool
 }
 
 func (it *Iterator) skipUnwanted() {
 loop:
-	if !it.iter.Valid() {
+	if !it.iter.Valid() || it.pastEnd() {
 		return
 	}
 	itm := (*Item)(it.iter.Get())
-	if itm.bornSn > it.snap.sn || (itm.deadSn > 0 && itm.deadSn <= it.snap.sn) {
+	if !itm.isVisible(it.snap) {
 		it.iter.Next()
 		it.count++
 		goto loop
 	}
 }
 
+func (it *Iterator) skipUnwantedBackward() {
+loop:
+	if !it.iter.Valid() || it.beforeStart() {
+		return
+	}
+	itm := (*Item)(it.iter.Get())
+	if !itm.isVisible(it.snap) {
+		it.iter.Prev()
+		it.count++
+		goto loop
+	}
+}
+
+// SetRange bounds the iterator to the range between start and end. A nil
+// start or end leaves that side of the range unbounded. The iterator
+// should be repositioned using SeekFirst or Seek after changing the range.
+func (it *Iterator) SetRange(start, end []byte, opts RangeOpts) {
+	it.start, it.end = nil, nil
+	if start != nil {
+		it.start = it.snap.db.newItem(start, nil, false)
+	}
+
+	if end != nil {
+		it.end = it.snap.db.newItem(end, nil, false)
+	}
+
+	it.opts = opts
+}
+
+func (it *Iterator) pastEnd() bool {
+	if it.end == nil {
+		return false
+	}
+
+	v := it.snap.db.keyCmp(it.Key(), it.end.Bytes())
+	return v > 0 || v == 0 && it.opts&IncludeEnd == 0
+}
+
+func (it *Iterator) beforeStart() bool {
+	if it.start == nil {
+		return false
+	}
+
+	v := it.snap.db.keyCmp(it.Key(), it.start.Bytes())
+	return v < 0 || v == 0 && it.opts&IncludeStart == 0
+}
+
 func (it *Iterator) SeekFirst() {
-	it.iter.SeekFirst()
+	it.reverse = false
+	if it.start == nil {
+		it.iter.SeekFirst()
+		it.skipUnwanted()
+		return
+	}
+
+	it.iter.Seek(unsafe.Pointer(it.start))
 	it.skipUnwanted()
+	if it.opts&IncludeStart == 0 && it.Valid() &&
+		it.snap.db.keyCmp(it.Key(), it.start.Bytes()) == 0 {
+		it.Next()
+	}
 }
 
 func (it *Iterator) Seek(bs []byte) {
-	itm := it.snap.db.newItem(bs, false)
+	if it.start != nil && it.snap.db.keyCmp(bs, it.start.Bytes()) <= 0 {
+		it.SeekFirst()
+		return
+	}
+
+	it.reverse = false
+	itm := it.snap.db.newItem(bs, nil, false)
 	it.iter.Seek(unsafe.Pointer(itm))
 	it.skipUnwanted()
 }
 
+func (it *Iterator) SeekLast() {
+	if it.end == nil {
+		it.reverse = true
+		it.iter.SeekLast()
+		it.skipUnwantedBackward()
+		return
+	}
+
+	it.seekForPrev(it.end.Bytes(), it.opts&IncludeEnd != 0)
+}
+
+// SeekForPrev positions the iterator at the last item which is less than
+// or equal to the key
+func (it *Iterator) SeekForPrev(bs []byte) {
+	if it.end != nil && it.snap.db.keyCmp(bs, it.end.Bytes()) >= 0 {
+		it.SeekLast()
+		return
+	}
+
+	it.seekForPrev(bs, true)
+}
+
+func (it *Iterator) seekForPrev(bs []byte, inclusive bool) {
+	it.reverse = true
+	// Items are ordered by (key, bornSn). Probe with the highest sn to land
+	// on the last version of the key or with the lowest to exclude the key.
+	itm := it.snap.db.newItem(bs, nil, false)
+	if inclusive {
+		itm.bornSn = math.MaxUint64
+	}
+	it.iter.SeekForPrev(unsafe.Pointer(itm))
+	it.skipUnwantedBackward()
+}
+
 func (it *Iterator) Valid() bool {
-	return it.iter.Valid()
+	if !it.iter.Valid() || it.snap.db.isAborted() {
+		return false
+	}
+
+	if it.reverse {
+		return !it.beforeStart()
+	}
+
+	return !it.pastEnd()
 }
 
 func (it *Iterator) Get() []byte {
+	return it.Key()
+}
+
+func (it *Iterator) Key() []byte {
 	return (*Item)(it.iter.Get()).Bytes()
 }
 
+func (it *Iterator) Value() []byte {
+	return (*Item)(it.iter.Get()).Value()
+}
+
 func (it *Iterator) GetNode() *skiplist.Node {
 	return it.iter.GetNode()
 }
 
 func (it *Iterator) Next() {
+	it.reverse = false
 	it.iter.Next()
 	it.count++
 	it.skipUnwanted()
@@ -60,13 +188,30 @@ func (it *Iterator) Next() {
 	}
 }
 
+func (it *Iterator) Prev() {
+	it.reverse = true
+	it.iter.Prev()
+	it.count++
+	it.skipUnwantedBackward()
+	if it.refreshRate > 0 && it.count > it.refreshRate {
+		it.Refresh()
+		it.count = 0
+	}
+}
+
 // Refresh can help safe-memory-reclaimer to free deleted objects
 func (it *Iterator) Refresh() {
 	if it.Valid() {
 		itm := it.snap.db.ptrToItem(it.GetNode().Item())
 		it.iter.Close()
-		it.iter = it.snap.db.store.NewIterator(it.snap.db.iterCmp, it.buf)
-		it.iter.Seek(unsafe.Pointer(itm))
+		it.iter = it.snap.db.store.NewIterator(it.snap.db.insCmp, it.buf)
+		if it.reverse {
+			it.iter.SeekForPrev(unsafe.Pointer(itm))
+			it.skipUnwantedBackward()
+		} else {
+			it.iter.Seek(unsafe.Pointer(itm))
+			it.skipUnwanted()
+		}
 	}
 }
 
@@ -87,7 +232,28 @@ func (m *MemDB) NewIterator(snap *Snapshot) *Iterator {
 	buf := snap.db.store.MakeBuf()
 	return &Iterator{
 		snap: snap,
-		iter: m.store.NewIterator(m.iterCmp, buf),
+		iter: m.store.NewIterator(m.insCmp, buf),
 		buf:  buf,
 	}
 }
+
+// NewRangeIterator returns an iterator bounded to the range between start
+// and end. opts decides whether the ends of the range are inclusive.
+func (s *Snapshot) NewRangeIterator(start, end []byte, opts RangeOpts) *Iterator {
+	itr := s.NewIterator()
+	if itr != nil {
+		itr.SetRange(start, end, opts)
+	}
+
+	return itr
+}
+
+// NewPrefixIterator returns an iterator over the keys starting with prefix
+func (s *Snapshot) NewPrefixIterator(prefix []byte) *Iterator {
+	succ := s.db.prefixSucc
+	if succ == nil {
+		succ = defaultPrefixSuccessor
+	}
+
+	return s.NewRangeIterator(prefix, succ(prefix), IncludeStart)
+}
@@ -2,6 +2,7 @@ package memdb
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
@@ -24,10 +25,16 @@ import (
 var (
 	ErrMaxSnapshotsLimitReached = fmt.Errorf("Maximum snapshots limit reached")
 	ErrShutdown                 = fmt.Errorf("MemDB instance has been shutdown")
+	ErrInvalidSnapshot          = fmt.Errorf("Snapshot is nil or has been closed")
+	ErrDiskFormat               = fmt.Errorf("Unsupported on-disk format version")
 )
 
 type KeyCompare func([]byte, []byte) int
 
+// PrefixSuccessor returns the smallest key which is greater than all the
+// keys starting with the prefix. nil denotes that there is no such key.
+type PrefixSuccessor func(prefix []byte) []byte
+
 type VisitorCallback func(*Item, int) error
 
 type ItemEntry struct {
@@ -49,9 +56,11 @@ type CheckPointCallback func()
 type FileType int
 
 const (
-	encodeBufSize      = 4
+	encodeBufSize      = 10
 	readerBufSize      = 10000
 	defaultRefreshRate = 10000
+	defaultGCWorkers   = 1
+	defaultFreeWorkers = 1
 )
 
 const (
@@ -61,6 +70,10 @@ const (
 
 const gcchanBufSize = 256
 
+// Version of the on-disk layout, recorded in files.json. Files written
+// before key/value items have no version and cannot be loaded.
+const diskFormatVersion = 1
+
 var (
 	dbInstances      *skiplist.Skiplist
 	dbInstancesCount int64
@@ -80,9 +93,12 @@ func CompareMemDB(this unsafe.Pointer, that unsafe.Pointer) int {
 func DefaultConfig() Config {
 	var cfg Config
 	cfg.SetKeyComparator(defaultKeyCmp)
+	cfg.SetPrefixSuccessor(defaultPrefixSuccessor)
 	cfg.SetFileType(RawdbFile)
 	cfg.useMemoryMgmt = false
 	cfg.refreshRate = defaultRefreshRate
+	cfg.gcWorkers = defaultGCWorkers
+	cfg.freeWorkers = defaultFreeWorkers
 	return cfg
 }
 
@@ -92,7 +108,10 @@ func newInsertCompare(keyCmp KeyCompare) skiplist.CompareFn {
 		thisItem := (*Item)(this)
 		thatItem := (*Item)(that)
 		if v = keyCmp(thisItem.Bytes(), thatItem.Bytes()); v == 0 {
-			v = int(thisItem.bornSn) - int(thatItem.bornSn)
+			v = compareSn(thisItem.bornSn, thatItem.bornSn)
+			if v == 0 {
+				v = int(thisItem.gen) - int(thatItem.gen)
+			}
 		}
 
 		return v
@@ -107,6 +126,7 @@ func newIterCompare(keyCmp KeyCompare) skiplist.CompareFn {
 	}
 }
 
+// Dead and expired items are treated as non-existent
 func newExistCompare(keyCmp KeyCompare) skiplist.CompareFn {
 	return func(this, that unsafe.Pointer) int {
 		thisItem := (*Item)(this)
@@ -114,6 +134,12 @@ func newExistCompare(keyCmp KeyCompare) skiplist.CompareFn {
 		if thisItem.deadSn != 0 || thatItem.deadSn != 0 {
 			return 1
 		}
+		if thisItem.expiry != 0 || thatItem.expiry != 0 {
+			now := unixNow()
+			if thisItem.hasExpired(now) || thatItem.hasExpired(now) {
+				return 1
+			}
+		}
 		return keyCmp(thisItem.Bytes(), thatItem.Bytes())
 	}
 }
@@ -122,6 +148,19 @@ func defaultKeyCmp(this, that []byte) int {
 	return bytes.Compare(this, that)
 }
 
+func defaultPrefixSuccessor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			succ := make([]byte, i+1)
+			copy(succ, prefix)
+			succ[i]++
+			return succ
+		}
+	}
+
+	return nil
+}
+
 const (
 	dwStateInactive = iota
 	dwStateInit
@@ -133,7 +172,7 @@ type deltaWrContext struct {
 	state        int
 	closed       chan struct{}
 	notifyStatus chan error
-	sn           uint32
+	sn           uint64
 	fw           FileWriter
 	err          error
 }
@@ -144,24 +183,36 @@ func (ctx *deltaWrContext) Init() {
 	ctx.closed = make(chan struct{})
 }
 
-type Writer struct {
+// Collection workers are shared by all the writers of a DB. Each worker
+// writes the items it collects during a disk snapshot into its own delta
+// file.
+type gcWorker struct {
 	dwrCtx deltaWrContext // Used for cooperative disk snapshotting
+	sts    skiplist.Stats
+}
 
+type Writer struct {
+	mu     sync.Mutex // Held for the duration of every update
 	rand   *rand.Rand
 	buf    *skiplist.ActionBuffer
 	gchead *skiplist.Node
 	gctail *skiplist.Node
+	gclen  int64
 	next   *Writer
-	// Local skiplist stats for writer, gcworker and freeworker
-	slSts1, slSts2, slSts3 skiplist.Stats
-	resSts                 restoreStats
-	count                  int64
+	id     int // Unique among the writers of the DB, for stats
+	// Local skiplist stats for writer
+	slSts1    skiplist.Stats
+	resSts    restoreStats
+	count     int64
+	mutations []Mutation // Captured for subscribers
+	overflow  bool       // Set when captured mutations were dropped
+	overQuota int32      // Set when the memory quota is found exceeded
 
 	*MemDB
 }
 
-func (w *Writer) doCheckpoint() {
-	ctx := &w.dwrCtx
+func (gw *gcWorker) doCheckpoint() {
+	ctx := &gw.dwrCtx
 	switch ctx.state {
 	case dwStateInit:
 		ctx.state = dwStateActive
@@ -173,8 +224,8 @@ func (w *Writer) doCheckpoint() {
 	}
 }
 
-func (w *Writer) doDeltaWrite(itm *Item) {
-	ctx := &w.dwrCtx
+func (gw *gcWorker) doDeltaWrite(itm *Item) {
+	ctx := &gw.dwrCtx
 	if ctx.state == dwStateActive {
 		if itm.bornSn <= ctx.sn && itm.deadSn > ctx.sn {
 			if err := ctx.fw.WriteItem(itm); err != nil {
@@ -189,13 +240,37 @@ func (w *Writer) Put(bs []byte) {
 }
 
 func (w *Writer) Put2(bs []byte) (n *skiplist.Node) {
+	return w.PutKV2(bs, nil)
+}
+
+func (w *Writer) PutKV(key, val []byte) {
+	w.PutKV2(key, val)
+}
+
+func (w *Writer) PutKV2(key, val []byte) (n *skiplist.Node) {
+	if w.checkQuota() != nil {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.put(key, val, w.getCurrSn())
+}
+
+func (w *Writer) put(key, val []byte, sn uint64) (n *skiplist.Node) {
+	return w.insert(w.newItem(key, val, w.useMemoryMgmt), sn)
+}
+
+func (w *Writer) insert(x *Item, sn uint64) (n *skiplist.Node) {
 	var success bool
-	x := w.newItem(bs, w.useMemoryMgmt)
-	x.bornSn = w.getCurrSn()
+	x.bornSn = sn
 	n, success = w.store.Insert2(unsafe.Pointer(x), w.insCmp, w.existCmp, w.buf,
 		w.rand.Float32, &w.slSts1)
 	if success {
 		w.count += 1
+		w.capture(MutationInsert, x.Bytes(), x.Value())
+		w.trackQuota()
 	} else {
 		w.freeItem(x)
 	}
@@ -208,13 +283,29 @@ func (w *Writer) Delete(bs []byte) (success bool) {
 	return
 }
 
+func (w *Writer) delete(bs []byte, sn uint64) (n *skiplist.Node, success bool) {
+	x := w.newItem(bs, nil, false)
+	x.bornSn = sn
+	if n = w.getNode(x); n != nil {
+		if success = w.deleteNode(n, sn); success {
+			w.count -= 1
+			w.capture(MutationDelete, bs, nil)
+		}
+	}
+
+	return
+}
+
 func (w *Writer) GetNode(bs []byte) *skiplist.Node {
+	x := w.newItem(bs, nil, false)
+	x.bornSn = w.getCurrSn()
+	return w.getNode(x)
+}
+
+func (w *Writer) getNode(x *Item) *skiplist.Node {
 	iter := w.store.NewIterator(w.iterCmp, w.buf)
 	defer iter.Close()
 
-	x := w.newItem(bs, false)
-	x.bornSn = w.getCurrSn()
-
 	if found := iter.SeekWithCmp(unsafe.Pointer(x), w.insCmp, w.existCmp); found {
 		return iter.GetNode()
 	}
@@ -223,33 +314,44 @@ func (w *Writer) GetNode(bs []byte) *skiplist.Node {
 }
 
 func (w *Writer) Delete2(bs []byte) (n *skiplist.Node, success bool) {
-	if n := w.GetNode(bs); n != nil {
-		return n, w.DeleteNode(n)
-	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-	return nil, false
+	return w.delete(bs, w.getCurrSn())
 }
 
 func (w *Writer) DeleteNode(x *skiplist.Node) (success bool) {
-	defer func() {
-		if success {
-			w.count -= 1
-		}
-	}()
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-	x.GClink = nil
-	sn := w.getCurrSn()
+	// Prevent the item from being freed until it is captured
+	barrier := w.store.GetAccesBarrier()
+	token := barrier.Acquire()
+	defer barrier.Release(token)
+
+	if success = w.deleteNode(x, w.getCurrSn()); success {
+		w.count -= 1
+		w.capture(MutationDelete, (*Item)(x.Item()).Bytes(), nil)
+	}
+
+	return
+}
+
+func (w *Writer) deleteNode(x *skiplist.Node, sn uint64) (success bool) {
 	gotItem := (*Item)(x.Item())
 	if gotItem.bornSn == sn {
 		success = w.store.DeleteNode(x, w.insCmp, w.buf, &w.slSts1)
-
-		barrier := w.store.GetAccesBarrier()
-		barrier.FlushSession(unsafe.Pointer(x))
+		if success {
+			x.GClink = nil
+			barrier := w.store.GetAccesBarrier()
+			barrier.FlushSession(unsafe.Pointer(x))
+		}
 		return
 	}
 
-	success = atomic.CompareAndSwapUint32(&gotItem.deadSn, 0, sn)
+	success = atomic.CompareAndSwapUint64(&gotItem.deadSn, 0, sn)
 	if success {
+		x.GClink = nil
 		if w.gctail == nil {
 			w.gctail = x
 			w.gchead = w.gctail
@@ -257,17 +359,234 @@ func (w *Writer) DeleteNode(x *skiplist.Node) (success bool) {
 			w.gctail.GClink = x
 			w.gctail = x
 		}
+		w.gclen++
+		if m := w.MemDB; m.autoSnapDeletes > 0 && w.gclen >= m.autoSnapDeletes {
+			m.triggerAutoSnapshot()
+		}
 	}
 	return
 }
 
+// Update replaces the live item matching old with new. Removal of the old
+// item and insertion of the new item share the same sequence number, so
+// that a snapshot observes exactly one of them. new should compare equal
+// to old as per the key comparator.
+func (w *Writer) Update(old, new []byte) bool {
+	success, _ := w.TryUpdate(old, new)
+	return success
+}
+
+// UpdateKV replaces the value of an existing key
+func (w *Writer) UpdateKV(key, val []byte) bool {
+	success, _ := w.TryUpdateKV(key, val)
+	return success
+}
+
+// Upsert inserts the item or replaces the live item comparing equal to it
+func (w *Writer) Upsert(bs []byte) bool {
+	success, _ := w.TryUpsert(bs)
+	return success
+}
+
+func (w *Writer) UpsertKV(key, val []byte) bool {
+	success, _ := w.TryUpsertKV(key, val)
+	return success
+}
+
+func (w *Writer) update(x *Item, upsert bool, match func(*Item) bool) (bool, error) {
+	if err := w.checkQuota(); err != nil {
+		w.freeItem(x)
+		return false, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, success := w.replace(x, w.getCurrSn(), upsert, match)
+	return success, nil
+}
+
+func (w *Writer) newSuccessorCompare(old *Item) skiplist.CompareFn {
+	return func(this, that unsafe.Pointer) int {
+		if w.insCmp(that, this) < 0 {
+			if that == unsafe.Pointer(old) {
+				return 1
+			}
+			return 0
+		}
+
+		return w.iterCmp(this, that)
+	}
+}
+
+// PutIfAbsent inserts the key/value only if there is no live item for the
+// key. Otherwise, a copy of the value of the live item is returned.
+// Writes rejected by the memory quota also return (nil, false), use
+// TryPutIfAbsent to tell them apart.
+func (w *Writer) PutIfAbsent(key, val []byte) ([]byte, bool) {
+	v, success, _ := w.TryPutIfAbsent(key, val)
+	return v, success
+}
+
+func (w *Writer) putIfAbsent(key, val []byte) ([]byte, bool, error) {
+	if err := w.checkQuota(); err != nil {
+		return nil, false, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	barrier := w.store.GetAccesBarrier()
+	token := barrier.Acquire()
+	defer barrier.Release(token)
+
+	x := w.newItem(key, val, w.useMemoryMgmt)
+	x.bornSn = w.getCurrSn()
+	for {
+		if _, success := w.store.Insert2(unsafe.Pointer(x), w.insCmp, w.existCmp, w.buf,
+			w.rand.Float32, &w.slSts1); success {
+			w.count += 1
+			w.capture(MutationInsert, key, val)
+			w.trackQuota()
+			return nil, true, nil
+		}
+
+		// Live item could have been deleted after the failed insert
+		if n := w.getNode(x); n != nil {
+			w.freeItem(x)
+			return append([]byte(nil), (*Item)(n.Item()).Value()...), false, nil
+		}
+	}
+}
+
+// DeleteIf deletes the live item for the key only if its value is equal
+// to expected
+func (w *Writer) DeleteIf(key, expected []byte) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	barrier := w.store.GetAccesBarrier()
+	token := barrier.Acquire()
+	defer barrier.Release(token)
+
+	sn := w.getCurrSn()
+	x := w.newItem(key, nil, false)
+	x.bornSn = sn
+	for {
+		n := w.getNode(x)
+		if n == nil || !bytes.Equal((*Item)(n.Item()).Value(), expected) {
+			return false
+		}
+
+		// Retry if the item was replaced or deleted by another writer
+		if w.deleteNode(n, sn) {
+			w.count -= 1
+			w.capture(MutationDelete, key, nil)
+			return true
+		}
+	}
+}
+
+// CompareAndSwap replaces the value of the live item for the key with new
+// only if its current value is equal to old
+func (w *Writer) CompareAndSwap(key, old, new []byte) bool {
+	success, _ := w.TryCompareAndSwap(key, old, new)
+	return success
+}
+
+func (w *Writer) compareAndSwap(key, old, new []byte) (bool, error) {
+	return w.update(w.newItem(key, new, w.useMemoryMgmt), false,
+		func(itm *Item) bool {
+			return bytes.Equal(itm.Value(), old)
+		})
+}
+
+// Replace the live item comparing equal to x with x. If match is non-nil,
+// the live item is replaced only if match returns true for it.
+func (w *Writer) replace(x *Item, sn uint64, upsert bool,
+	match func(*Item) bool) (n *skiplist.Node, success bool) {
+	// Prevent the live item from being freed while it is being inspected
+	barrier := w.store.GetAccesBarrier()
+	token := barrier.Acquire()
+	defer barrier.Release(token)
+
+	x.bornSn = sn
+
+retry:
+	old := w.getNode(x)
+	if old != nil && match != nil && !match((*Item)(old.Item())) {
+		w.freeItem(x)
+		return nil, false
+	}
+
+	if old == nil {
+		if !upsert {
+			w.freeItem(x)
+			return nil, false
+		}
+
+		// Lost the race against a concurrent insert, replace it instead
+		if n, success = w.store.Insert2(unsafe.Pointer(x), w.insCmp, w.existCmp, w.buf,
+			w.rand.Float32, &w.slSts1); !success {
+			goto retry
+		}
+		w.count += 1
+		w.capture(MutationInsert, x.Bytes(), x.Value())
+	} else {
+		// Versions created under the same sn are ordered by generation
+		oldItm := (*Item)(old.Item())
+		x.gen = 0
+		if oldItm.bornSn == sn {
+			x.gen = oldItm.gen + 1
+		}
+
+		// New version is inserted before the old version is marked as dead.
+		// A concurrent insert for the same key cannot sneak in between.
+		// Insert fails unless the new version directly follows the old
+		// version and no newer version of the key exists.
+		if n, success = w.store.Insert2(unsafe.Pointer(x), w.insCmp,
+			w.newSuccessorCompare(oldItm), w.buf, w.rand.Float32, &w.slSts1); !success {
+			goto retry
+		}
+
+		if !w.deleteNode(old, sn) {
+			// Old version was replaced or deleted by another writer.
+			// Retract the new version and retry with a fresh copy.
+			y := w.newItem(x.Bytes(), x.Value(), w.useMemoryMgmt)
+			y.bornSn, y.expiry = sn, x.expiry
+			x = y
+			w.deleteNode(n, sn)
+			goto retry
+		}
+		w.capture(MutationUpdate, x.Bytes(), x.Value())
+	}
+
+	w.trackQuota()
+	return n, true
+}
+
 type Config struct {
 	keyCmp      KeyCompare
+	prefixSucc  PrefixSuccessor
 	insCmp      skiplist.CompareFn
 	iterCmp     skiplist.CompareFn
 	existCmp    skiplist.CompareFn
 	refreshRate int
 
+	expiryInterval time.Duration
+
+	gcWorkers   int
+	freeWorkers int
+
+	retainSns    uint64
+	retainPeriod time.Duration
+
+	quota       int64
+	quotaPolicy QuotaPolicy
+
+	autoSnapInterval time.Duration
+	autoSnapDeletes  int64
+
 	ignoreItemSize bool
 
 	fileType FileType
@@ -285,6 +604,12 @@ func (cfg *Config) SetKeyComparator(cmp KeyCompare) {
 	cfg.existCmp = newExistCompare(cmp)
 }
 
+// Prefix iterators use the successor function to find the end of a prefix
+// range. The default works for comparators which agree with bytes.Compare.
+func (cfg *Config) SetPrefixSuccessor(fn PrefixSuccessor) {
+	cfg.prefixSucc = fn
+}
+
 func (cfg *Config) SetFileType(t FileType) error {
 	switch t {
 	case ForestdbFile, RawdbFile:
@@ -296,6 +621,54 @@ func (cfg *Config) SetFileType(t FileType) error {
 	return nil
 }
 
+// Expired items are hidden from snapshots. The expiry worker periodically
+// deletes them so that the snapshot GC can reclaim the memory. A zero
+// interval disables the worker.
+func (cfg *Config) SetExpiryInterval(d time.Duration) {
+	cfg.expiryInterval = d
+}
+
+// Closed snapshots within the last sns sequence numbers or created within
+// the last period are not garbage collected. They can be reopened using
+// OpenSnapshotAt. A zero value disables the respective policy.
+func (cfg *Config) SetSnapshotRetention(sns uint64, period time.Duration) {
+	cfg.retainSns = sns
+	cfg.retainPeriod = period
+}
+
+// Limits the memory used by the MemDB instance. With QuotaReject, writes
+// fail while the quota is exceeded. The Try variants of the writes report
+// ErrMemoryQuotaExceeded, other writes report the failure through their
+// return values. Deletes are never rejected. With QuotaBlock, writers wait
+// until the GC reclaims enough memory, or fail with ErrShutdown once the
+// MemDB is closed. The quota may be exceeded by up to 1MB per writer.
+func (cfg *Config) SetMemoryQuota(bytes int64, policy QuotaPolicy) {
+	cfg.quota = bytes
+	cfg.quotaPolicy = policy
+}
+
+// Deleted items are reclaimed only after a snapshot is created and closed.
+// The auto snapshot worker creates and releases a snapshot every interval,
+// unless one was created within the interval, and whenever a writer has
+// maxPendingDeletes deletes pending. Auto snapshots are considered for
+// snapshot retention. A zero value disables the respective trigger.
+func (cfg *Config) SetAutoSnapshot(interval time.Duration, maxPendingDeletes int64) {
+	cfg.autoSnapInterval = interval
+	cfg.autoSnapDeletes = maxPendingDeletes
+}
+
+// Sets the number of goroutines which unlink the items of collected
+// snapshots from the store. Defaults to 1.
+func (cfg *Config) SetGCWorkers(n int) {
+	cfg.gcWorkers = n
+}
+
+// Sets the number of goroutines which free the memory of unlinked items.
+// Applies only if memory management is enabled. Defaults to 1.
+func (cfg *Config) SetFreeWorkers(n int) {
+	cfg.freeWorkers = n
+}
+
 func (cfg *Config) IgnoreItemSize() {
 	cfg.ignoreItemSize = true
 }
@@ -318,21 +691,73 @@ type restoreStats struct {
 }
 
 type MemDB struct {
+	currSn       uint64 // Accessed atomically, should be 64-bit aligned
 	id           int
 	store        *skiplist.Skiplist
-	currSn       uint32
 	snapshots    *skiplist.Skiplist
 	gcsnapshots  *skiplist.Skiplist
-	isGCRunning  int32
-	lastGCSn     uint32
-	leastUnrefSn uint32
+	aborted      int32 // Set when outstanding snapshots are invalidated
+	lastGCSn     uint64
+	leastUnrefSn uint64
 	itemsCount   int64
+	numSnaps     int64
 
 	wlist    *Writer
+	wlock    sync.Mutex // Protects wlist and the state handed over by closed writers
+	writerID int        // Id of the next writer, protected by wlock
 	gcchan   chan *skiplist.Node
 	freechan chan *skiplist.Node
 
+	// Holds the token while collecting dead snapshots. Close takes the
+	// token and never returns it.
+	gcToken chan struct{}
+
+	// Runs GC once the oldest snapshot retained for a period ages out.
+	// Protected by gcToken.
+	retainTimer *time.Timer
+
+	// Notified whenever a snapshot is closed
+	snapClosed chan struct{}
+
+	gcTracker *gcTracker
+
+	// Protects freechan against barrier destructors running after Close
+	freeLock   sync.RWMutex
+	freeClosed bool
+
+	// Pending gclist and items count of closed writers
+	closedGCHead   *skiplist.Node
+	closedGCTail   *skiplist.Node
+	closedGCLen    int64
+	closedCount    int64
+	closedMuts     []Mutation
+	closedOverflow bool
+
+	subs    []*Subscription // Protected by wlock
+	numSubs int32
+
+	gcWorkerPool []*gcWorker
+	workersOnce  sync.Once
+	dwrLock      sync.Mutex // Serializes delta write state changes
+
+	expiryStop chan struct{}
+	expiryWg   sync.WaitGroup
+
+	autoSnapStop    chan struct{}
+	autoSnapTrigger chan struct{}
+	autoSnapWg      sync.WaitGroup
+	autoSnapMu      sync.Mutex
+	autoSnapPaused  int   // Protected by autoSnapMu
+	lastSnapTime    int64 // Unix nanoseconds, accessed atomically
+
+	txnOnce   sync.Once
+	txnWriter *Writer
+
+	// Free list of item buffers used as lookup keys
+	probePool sync.Pool
+
 	hasShutdown bool
+	shutdownCh  chan struct{}  // Closed by Close
 	shutdownWg1 sync.WaitGroup // GC workers and StoreToDisk task
 	shutdownWg2 sync.WaitGroup // Free workers
 
@@ -347,9 +772,17 @@ func NewWithConfig(cfg Config) *MemDB {
 		currSn:      1,
 		Config:      cfg,
 		gcchan:      make(chan *skiplist.Node, gcchanBufSize),
+		gcToken:     make(chan struct{}, 1),
+		snapClosed:  make(chan struct{}, 1),
+		shutdownCh:  make(chan struct{}),
+		gcTracker:   newGCTracker(),
 		id:          int(atomic.AddInt64(&dbInstancesCount, 1)),
 	}
 
+	m.gcToken <- struct{}{}
+	m.probePool.New = func() interface{} {
+		return new([]byte)
+	}
 	m.freechan = make(chan *skiplist.Node, gcchanBufSize)
 	m.store = skiplist.NewWithConfig(m.newStoreConfig())
 	m.initSizeFuns()
@@ -358,6 +791,10 @@ func NewWithConfig(cfg Config) *MemDB {
 	defer dbInstances.FreeBuf(buf)
 	dbInstances.Insert(unsafe.Pointer(m), CompareMemDB, buf, &dbInstances.Stats)
 
+	if m.hasAutoSnapshot() {
+		m.startAutoSnapshot()
+	}
+
 	return m
 
 }
@@ -378,8 +815,15 @@ func (m *MemDB) newBSDestructor() skiplist.BarrierSessionDestructor {
 	return func(ref unsafe.Pointer) {
 		// If gclist is not empty
 		if ref != nil {
-			freelist := (*skiplist.Node)(ref)
-			m.freechan <- freelist
+			m.freeLock.RLock()
+			defer m.freeLock.RUnlock()
+
+			// Nodes released after an aborted Close are leaked
+			if !m.freeClosed {
+				freelist := (*skiplist.Node)(ref)
+				m.gcFreeQueue(freelist)
+				m.freechan <- freelist
+			}
 		}
 	}
 }
@@ -401,33 +845,82 @@ func (m *MemDB) MemoryInUse() int64 {
 	return storeStats.Memory + m.snapshots.MemoryInUse() + m.gcsnapshots.MemoryInUse()
 }
 
+// Close waits until all the snapshots are closed and releases the resources
 func (m *MemDB) Close() {
+	m.CloseWithContext(context.Background())
+}
+
+// CloseWithContext waits until all the snapshots are closed and releases
+// the resources. If ctx is done before that, the snapshots which are still
+// open are invalidated and returned along with the context error. Iterators
+// of an invalidated snapshot are no longer valid and lookups do not find
+// any item. Memory which may be referenced by them is not freed.
+func (m *MemDB) CloseWithContext(ctx context.Context) ([]*Snapshot, error) {
+	var openSnaps []*Snapshot
+	var err error
+
+	m.stopAutoSnapshot()
+
 	// Wait until all snapshot iterators have finished
-	for s := m.snapshots.GetStats(); int(s.NodeCount) != 0; s = m.snapshots.GetStats() {
-		time.Sleep(time.Millisecond)
+	for atomic.LoadInt64(&m.numSnaps) != 0 && err == nil {
+		select {
+		case <-m.snapClosed:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
 	}
 
+	if err != nil {
+		atomic.StoreInt32(&m.aborted, 1)
+		openSnaps = m.GetSnapshots()
+	}
+
+	m.wlock.Lock()
 	m.hasShutdown = true
+	close(m.shutdownCh)
+	for len(m.subs) > 0 {
+		m.unsubscribe(m.subs[0], ErrShutdown)
+	}
+	m.wlock.Unlock()
+
+	// No workers are started beyond this point
+	m.workersOnce.Do(func() {})
+	if m.expiryStop != nil {
+		close(m.expiryStop)
+		m.expiryWg.Wait()
+	}
 
 	// Acquire gc chan ownership
 	// This will make sure that no other goroutine will write to gcchan
-	for !atomic.CompareAndSwapInt32(&m.isGCRunning, 0, 1) {
-		time.Sleep(time.Millisecond)
+	<-m.gcToken
+	if m.retainTimer != nil {
+		m.retainTimer.Stop()
 	}
 	close(m.gcchan)
+	m.gcShutdown()
 
 	buf := dbInstances.MakeBuf()
 	defer dbInstances.FreeBuf(buf)
 	dbInstances.Delete(unsafe.Pointer(m), CompareMemDB, buf, &dbInstances.Stats)
+	if atomic.LoadInt64(&processQuota) > 0 {
+		notifyReclaim()
+	}
 
 	if m.useMemoryMgmt {
 		buf := m.snapshots.MakeBuf()
 		defer m.snapshots.FreeBuf(buf)
 
 		m.shutdownWg1.Wait()
+		m.freeLock.Lock()
+		m.freeClosed = true
 		close(m.freechan)
+		m.freeLock.Unlock()
 		m.shutdownWg2.Wait()
 
+		if err != nil {
+			return openSnaps, err
+		}
+
 		// Manually free up all nodes
 		iter := m.store.NewIterator(m.iterCmp, buf)
 		defer iter.Close()
@@ -450,10 +943,16 @@ func (m *MemDB) Close() {
 			}
 		}
 	}
+
+	return openSnaps, err
+}
+
+func (m *MemDB) isAborted() bool {
+	return atomic.LoadInt32(&m.aborted) == 1
 }
 
-func (m *MemDB) getCurrSn() uint32 {
-	return atomic.LoadUint32(&m.currSn)
+func (m *MemDB) getCurrSn() uint64 {
+	return atomic.LoadUint64(&m.currSn)
 }
 
 func (m *MemDB) newWriter() *Writer {
@@ -464,54 +963,111 @@ func (m *MemDB) newWriter() *Writer {
 	}
 
 	w.slSts1.IsLocal(true)
-	w.slSts2.IsLocal(true)
-	w.slSts3.IsLocal(true)
 	return w
 }
 
 func (m *MemDB) NewWriter() *Writer {
-	w := m.newWriter()
+	m.workersOnce.Do(m.startWorkers)
+	return m.registerWriter(m.newWriter())
+}
+
+func (m *MemDB) registerWriter(w *Writer) *Writer {
+	m.wlock.Lock()
+	w.id = m.writerID
+	m.writerID++
 	w.next = m.wlist
 	m.wlist = w
-	w.dwrCtx.Init()
+	m.wlock.Unlock()
 
-	m.shutdownWg1.Add(1)
-	go m.collectionWorker(w)
-	if m.useMemoryMgmt {
-		m.shutdownWg2.Add(1)
-		go m.freeWorker(w)
+	return w
+}
+
+// Close deregisters the writer. Pending deletes and
+// stats of the writer are handed over to the DB, to be picked up by the
+// next snapshot. The writer should not be used after it is closed.
+func (w *Writer) Close() {
+	m := w.MemDB
+	m.wlock.Lock()
+	found := false
+	for pw := &m.wlist; *pw != nil; pw = &(*pw).next {
+		if *pw == w {
+			*pw = w.next
+			found = true
+			break
+		}
 	}
 
-	return w
+	if !found {
+		m.wlock.Unlock()
+		return
+	}
+
+	w.mu.Lock()
+	if w.gchead != nil {
+		if m.closedGCTail == nil {
+			m.closedGCHead = w.gchead
+		} else {
+			m.closedGCTail.GClink = w.gchead
+		}
+		m.closedGCTail = w.gctail
+		m.closedGCLen += w.gclen
+		w.gchead = nil
+		w.gctail = nil
+		w.gclen = 0
+	}
+
+	m.closedCount += w.count
+	w.count = 0
+	m.closedMuts = append(m.closedMuts, w.mutations...)
+	m.closedOverflow = m.closedOverflow || w.overflow
+	w.mutations = nil
+	w.overflow = false
+	m.store.Stats.Merge(&w.slSts1)
+	w.mu.Unlock()
+	m.wlock.Unlock()
+
+	m.store.FreeBuf(w.buf)
 }
 
 type Snapshot struct {
-	sn       uint32
+	sn       uint64
+	ts       uint32
 	refCount int32
 	db       *MemDB
 	count    int64
 
+	// Opened using OpenSnapshotAt, does not own a gclist
+	historical bool
+
 	gclist *skiplist.Node
+	gclen  int64
 }
 
 func SnapshotSize(p unsafe.Pointer) int {
 	s := (*Snapshot)(p)
-	return int(unsafe.Sizeof(s.sn) + unsafe.Sizeof(s.refCount) + unsafe.Sizeof(s.db) +
-		unsafe.Sizeof(s.count) + unsafe.Sizeof(s.gclist))
+	return int(unsafe.Sizeof(s.sn) + unsafe.Sizeof(s.ts) + unsafe.Sizeof(s.refCount) + unsafe.Sizeof(s.db) +
+		unsafe.Sizeof(s.count) + unsafe.Sizeof(s.historical) + unsafe.Sizeof(s.gclist) + unsafe.Sizeof(s.gclen))
 }
 
 func (s Snapshot) Count() int64 {
 	return s.count
 }
 
+// Snapshot encoding format
+// | marker - 4 bytes | sn - 8 bytes |
+// Older encodings consist of a 4 byte sn, which can never be equal to the
+// marker value.
+const snapshotEncMarker = math.MaxUint32
+
 func (s *Snapshot) Encode(buf []byte, w io.Writer) error {
-	l := 4
+	l := 12
 	if len(buf) < l {
 		return ErrNotEnoughSpace
 	}
 
-	binary.BigEndian.PutUint32(buf[0:4], s.sn)
-	if _, err := w.Write(buf[0:4]); err != nil {
+	binary.BigEndian.PutUint32(buf[0:4], snapshotEncMarker)
+	binary.BigEndian.PutUint64(buf[4:12], s.sn)
+	if _, err := w.Write(buf[0:12]); err != nil {
 		return err
 	}
 
@@ -523,12 +1079,25 @@ func (s *Snapshot) Decode(buf []byte, r io.Reader) error {
 	if _, err := io.ReadFull(r, buf[0:4]); err != nil {
 		return err
 	}
-	s.sn = binary.BigEndian.Uint32(buf[0:4])
+
+	if sn := binary.BigEndian.Uint32(buf[0:4]); sn != snapshotEncMarker {
+		s.sn = uint64(sn)
+		return nil
+	}
+
+	if len(buf) < 12 {
+		return ErrNotEnoughSpace
+	}
+
+	if _, err := io.ReadFull(r, buf[4:12]); err != nil {
+		return err
+	}
+	s.sn = binary.BigEndian.Uint64(buf[4:12])
 	return nil
 }
 
 func (s *Snapshot) Open() bool {
-	if atomic.LoadInt32(&s.refCount) == 0 {
+	if atomic.LoadInt32(&s.refCount) == 0 || s.db.isAborted() {
 		return false
 	}
 	atomic.AddInt32(&s.refCount, 1)
@@ -541,10 +1110,19 @@ func (s *Snapshot) Close() {
 		buf := s.db.snapshots.MakeBuf()
 		defer s.db.snapshots.FreeBuf(buf)
 
-		// Move from live snapshot list to dead list
+		// Move from live snapshot list to dead list. The snapshot is always
+		// present in one of the lists for OpenSnapshotAt.
+		if !s.historical {
+			s.db.gcsnapshots.Insert(unsafe.Pointer(s), CompareSnapshot, buf, &s.db.gcsnapshots.Stats)
+		}
 		s.db.snapshots.Delete(unsafe.Pointer(s), CompareSnapshot, buf, &s.db.snapshots.Stats)
-		s.db.gcsnapshots.Insert(unsafe.Pointer(s), CompareSnapshot, buf, &s.db.gcsnapshots.Stats)
 		s.db.GC()
+		atomic.AddInt64(&s.db.numSnaps, -1)
+
+		select {
+		case s.db.snapClosed <- struct{}{}:
+		default:
+		}
 	}
 }
 
@@ -552,19 +1130,127 @@ func (s *Snapshot) NewIterator() *Iterator {
 	return s.db.NewIterator(s)
 }
 
+// Get returns the value of the item matching the key which is visible in
+// the snapshot. The returned bytes are valid until the snapshot is closed.
+func (s *Snapshot) Get(bs []byte) ([]byte, bool) {
+	buf := s.db.store.MakeBuf()
+	defer s.db.store.FreeBuf(buf)
+
+	return s.get(bs, buf)
+}
+
+// MultiGet performs Get for a batch of keys using a single action buffer
+func (s *Snapshot) MultiGet(keys [][]byte) (vals [][]byte, found []bool) {
+	buf := s.db.store.MakeBuf()
+	defer s.db.store.FreeBuf(buf)
+
+	vals = make([][]byte, len(keys))
+	found = make([]bool, len(keys))
+	for i, k := range keys {
+		vals[i], found[i] = s.get(k, buf)
+	}
+
+	return
+}
+
+func (s *Snapshot) get(bs []byte, buf *skiplist.ActionBuffer) (val []byte, found bool) {
+	if s.db.isAborted() {
+		return
+	}
+
+	probe := s.db.probePool.Get().(*[]byte)
+	defer s.db.probePool.Put(probe)
+
+	x := s.db.probeItem(bs, probe)
+	s.db.store.Lookup(unsafe.Pointer(x), s.db.iterCmp, buf, &s.db.store.Stats,
+		func(n *skiplist.Node) bool {
+			itm := (*Item)(n.Item())
+			if itm.isVisible(s) {
+				val = itm.Value()
+				found = true
+				return false
+			}
+			return true
+		})
+
+	return
+}
+
+// Snapshots are ordered by sn. Historical snapshots sharing the sn of the
+// original snapshot are ordered after it.
 func CompareSnapshot(this, that unsafe.Pointer) int {
 	thisItem := (*Snapshot)(this)
 	thatItem := (*Snapshot)(that)
 
-	return int(thisItem.sn) - int(thatItem.sn)
+	if cmp := compareSn(thisItem.sn, thatItem.sn); cmp != 0 {
+		return cmp
+	}
+
+	switch {
+	case !thisItem.historical && !thatItem.historical:
+		return 0
+	case !thisItem.historical:
+		return -1
+	case !thatItem.historical:
+		return 1
+	}
+
+	return compareSn(uint64(uintptr(this)), uint64(uintptr(that)))
 }
 
+func compareSn(this, that uint64) int {
+	switch {
+	case this < that:
+		return -1
+	case this > that:
+		return 1
+	}
+
+	return 0
+}
+
+// NewSnapshot can be called concurrently with writer operations. Every
+// writer operation holds the writer mutex while it reads the current sn and
+// updates the writer gclist and count. Snapshot creation holds wlock and the
+// mutex of every writer, hence each writer is observed between operations.
+// An operation belongs to a single snapshot and the gclist handed over to
+// the snapshot is never modified concurrently. Lock order is wlock followed
+// by writer mutexes.
 func (m *MemDB) NewSnapshot() (*Snapshot, error) {
+	m.workersOnce.Do(m.startWorkers)
+
+	// Collect the snapshots which fall out of the retention window
+	if m.hasRetention() {
+		defer m.GC()
+	}
+
+	m.wlock.Lock()
+	defer m.wlock.Unlock()
+
+	// Wait for in-flight batches and hold off new ones until the
+	// sequence number has been incremented
+	for w := m.wlist; w != nil; w = w.next {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+	}
+
+	return m.newSnapshot()
+}
+
+// Called with wlock and the mutex of all the writers held
+func (m *MemDB) newSnapshot() (*Snapshot, error) {
 	buf := m.snapshots.MakeBuf()
 	defer m.snapshots.FreeBuf(buf)
 
 	// Stitch all local gclists from all writers to create snapshot gclist
-	var head, tail *skiplist.Node
+	head, tail := m.closedGCHead, m.closedGCTail
+	gclen := m.closedGCLen
+	m.closedGCHead, m.closedGCTail = nil, nil
+	m.closedGCLen = 0
+	atomic.AddInt64(&m.itemsCount, m.closedCount)
+	m.closedCount = 0
+	muts, overflow := m.closedMuts, m.closedOverflow
+	m.closedMuts, m.closedOverflow = nil, false
 
 	for w := m.wlist; w != nil; w = w.next {
 		if tail == nil {
@@ -577,18 +1263,27 @@ func (m *MemDB) NewSnapshot() (*Snapshot, error) {
 
 		w.gchead = nil
 		w.gctail = nil
+		gclen += w.gclen
+		w.gclen = 0
 
 		// Update global stats
 		m.store.Stats.Merge(&w.slSts1)
 		atomic.AddInt64(&m.itemsCount, w.count)
 		w.count = 0
+		muts = append(muts, w.mutations...)
+		overflow = overflow || w.overflow
+		w.mutations = w.mutations[:0]
+		w.overflow = false
 	}
 
-	snap := &Snapshot{db: m, sn: m.getCurrSn(), refCount: 1, count: m.ItemsCount()}
+	snap := &Snapshot{db: m, sn: m.getCurrSn(), ts: unixNow(), refCount: 1, count: m.ItemsCount(), gclen: gclen}
 	m.snapshots.Insert(unsafe.Pointer(snap), CompareSnapshot, buf, &m.snapshots.Stats)
+	atomic.AddInt64(&m.numSnaps, 1)
+	atomic.StoreInt64(&m.lastSnapTime, time.Now().UnixNano())
+	m.publish(snap.sn, muts, overflow)
 	snap.gclist = head
-	newSn := atomic.AddUint32(&m.currSn, 1)
-	if newSn == math.MaxUint32 {
+	newSn := atomic.AddUint64(&m.currSn, 1)
+	if newSn == math.MaxUint64 {
 		return nil, ErrMaxSnapshotsLimitReached
 	}
 
@@ -599,26 +1294,74 @@ func (m *MemDB) ItemsCount() int64 {
 	return atomic.LoadInt64(&m.itemsCount)
 }
 
-func (m *MemDB) collectionWorker(w *Writer) {
+// Background workers start along with the first writer or snapshot, so that
+// LoadFromDisk can replace the store before that. They run until the DB is
+// closed.
+func (m *MemDB) startWorkers() {
+	m.startGCWorkers()
+
+	if m.expiryInterval > 0 {
+		m.expiryStop = make(chan struct{})
+		m.expiryWg.Add(1)
+		go m.expiryWorker(m.registerWriter(m.newWriter()))
+	}
+}
+
+func (m *MemDB) startGCWorkers() {
+	n := m.gcWorkers
+	if n <= 0 {
+		n = defaultGCWorkers
+	}
+
+	m.gcWorkerPool = make([]*gcWorker, n)
+	for i := range m.gcWorkerPool {
+		gw := &gcWorker{}
+		gw.dwrCtx.Init()
+		gw.sts.IsLocal(true)
+		m.gcWorkerPool[i] = gw
+
+		m.shutdownWg1.Add(1)
+		go m.collectionWorker(gw)
+	}
+
+	if m.useMemoryMgmt {
+		n := m.freeWorkers
+		if n <= 0 {
+			n = defaultFreeWorkers
+		}
+
+		for i := 0; i < n; i++ {
+			m.shutdownWg2.Add(1)
+			go m.freeWorker()
+		}
+	}
+}
+
+func (m *MemDB) collectionWorker(gw *gcWorker) {
 	buf := m.store.MakeBuf()
 	defer m.store.FreeBuf(buf)
 	defer m.shutdownWg1.Done()
 
 	for {
 		select {
-		case <-w.dwrCtx.notifyStatus:
-			w.doCheckpoint()
+		case <-gw.dwrCtx.notifyStatus:
+			gw.doCheckpoint()
 		case gclist, ok := <-m.gcchan:
 			if !ok {
-				close(w.dwrCtx.closed)
+				close(gw.dwrCtx.closed)
 				return
 			}
+			m.gcUnlinkStart(gclist)
 			for n := gclist; n != nil; n = n.GClink {
-				w.doDeltaWrite((*Item)(n.Item()))
-				m.store.DeleteNode(n, m.insCmp, buf, &w.slSts2)
+				gw.doDeltaWrite((*Item)(n.Item()))
+				m.store.DeleteNode(n, m.insCmp, buf, &gw.sts)
 			}
 
-			m.store.Stats.Merge(&w.slSts2)
+			m.store.Stats.Merge(&gw.sts)
+			m.gcUnlinkDone(gclist)
+			if m.hasQuota() {
+				notifyReclaim()
+			}
 
 			barrier := m.store.GetAccesBarrier()
 			barrier.FlushSession(unsafe.Pointer(gclist))
@@ -626,21 +1369,32 @@ func (m *MemDB) collectionWorker(w *Writer) {
 	}
 }
 
-func (m *MemDB) freeWorker(w *Writer) {
+func (m *MemDB) freeWorker() {
+	defer m.shutdownWg2.Done()
+
+	var sts skiplist.Stats
+	sts.IsLocal(true)
+
 	for freelist := range m.freechan {
+
+		sn, tracked := m.gcFreeStart(freelist)
 		for n := freelist; n != nil; {
 			dnode := n
 			n = n.GClink
 
 			itm := (*Item)(dnode.Item())
 			m.freeItem(itm)
-			m.store.FreeNode(dnode, &w.slSts3)
+			m.store.FreeNode(dnode, &sts)
 		}
 
-		m.store.Stats.Merge(&w.slSts3)
+		m.store.Stats.Merge(&sts)
+		if tracked {
+			m.gcFreeDone(sn)
+		}
+		if m.hasQuota() {
+			notifyReclaim()
+		}
 	}
-
-	m.shutdownWg2.Done()
 }
 
 // Invarient: Each snapshot n is dependent on snapshot n-1.
@@ -654,23 +1408,31 @@ func (m *MemDB) collectDead() {
 	iter := m.gcsnapshots.NewIterator(CompareSnapshot, buf1)
 	defer iter.Close()
 
+	minLiveSn := m.minLiveSn()
 	for iter.SeekFirst(); iter.Valid(); iter.Next() {
 		node := iter.GetNode()
 		sn := (*Snapshot)(node.Item())
-		if sn.sn != m.lastGCSn+1 {
+		if sn.sn != m.lastGCSn+1 || sn.sn > minLiveSn {
+			return
+		}
+
+		if m.isRetained(sn) {
+			m.scheduleRetentionGC(sn)
 			return
 		}
 
-		m.lastGCSn = sn.sn
+		m.gcQueue(sn.sn, sn.gclist)
 		m.gcchan <- sn.gclist
 		m.gcsnapshots.DeleteNode(node, CompareSnapshot, buf2, &m.gcsnapshots.Stats)
 	}
 }
 
 func (m *MemDB) GC() {
-	if atomic.CompareAndSwapInt32(&m.isGCRunning, 0, 1) {
+	select {
+	case <-m.gcToken:
 		m.collectDead()
-		atomic.CompareAndSwapInt32(&m.isGCRunning, 1, 0)
+		m.gcToken <- struct{}{}
+	default:
 	}
 }
 
@@ -689,26 +1451,103 @@ func (m *MemDB) GetSnapshots() []*Snapshot {
 
 func (m *MemDB) ptrToItem(itmPtr unsafe.Pointer) *Item {
 	o := (*Item)(itmPtr)
-	itm := m.newItem(o.Bytes(), false)
+	itm := m.newItem(o.Bytes(), o.Value(), false)
 	*itm = *o
 
 	return itm
 }
 
+// Visitor calls callb for all the items in the snapshot. The items are
+// split into shards which are visited concurrently. The error of the first
+// failed shard is returned.
 func (m *MemDB) Visitor(snap *Snapshot, callb VisitorCallback, shards int, concurrency int) error {
+	err := m.VisitorWithOptions(context.Background(), snap, callb, VisitorOptions{
+		Shards:      shards,
+		Concurrency: concurrency,
+	})
+
+	if errs, ok := err.(ShardErrors); ok {
+		return errs.First()
+	}
+
+	return err
+}
+
+type VisitorOptions struct {
+	Shards      int
+	Concurrency int
+
+	// Restricts the visitor to the range [Start, End). A nil Start or End
+	// leaves that side of the range unbounded.
+	Start, End []byte
+
+	// Progress is called with the total items and bytes visited in a shard
+	// after every ProgressInterval items and when the shard is finished.
+	// It is called concurrently from the visitor workers.
+	Progress         func(shard int, items, bytes int64)
+	ProgressInterval int
+}
+
+// ShardErrors holds the errors of the visitor shards which failed
+type ShardErrors map[int]error
+
+func (errs ShardErrors) Error() string {
+	first := errs.First()
+	if len(errs) == 1 {
+		return first.Error()
+	}
+
+	return fmt.Sprintf("%v (and %d more shard errors)", first, len(errs)-1)
+}
+
+// First returns the error of the lowest failed shard
+func (errs ShardErrors) First() error {
+	shard := -1
+	for s := range errs {
+		if shard < 0 || s < shard {
+			shard = s
+		}
+	}
+
+	return errs[shard]
+}
+
+// Number of items between context cancellation checks
+const visitorCancelCheckInterval = 1024
+
+// VisitorWithOptions is the cancellable form of Visitor. Visiting stops
+// once ctx is done. Errors of individual shards, including cancellation,
+// are returned as ShardErrors.
+func (m *MemDB) VisitorWithOptions(ctx context.Context, snap *Snapshot,
+	callb VisitorCallback, opts VisitorOptions) error {
 	var wg sync.WaitGroup
 	var pivotItems []*Item
 
-	wch := make(chan int, shards)
-
 	if snap == nil {
-		panic("snapshot cannot be nil")
+		return ErrInvalidSnapshot
+	}
+
+	if opts.Shards < 1 {
+		opts.Shards = 1
+	}
+
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+
+	var startItm, endItm *Item
+	if opts.Start != nil {
+		startItm = m.newItem(opts.Start, nil, false)
 	}
 
-	func() {
+	if opts.End != nil {
+		endItm = m.newItem(opts.End, nil, false)
+	}
+
+	err := func() error {
 		tmpIter := m.NewIterator(snap)
 		if tmpIter == nil {
-			panic("iterator cannot be nil")
+			return ErrInvalidSnapshot
 		}
 		defer tmpIter.Close()
 
@@ -716,80 +1555,132 @@ func (m *MemDB) Visitor(snap *Snapshot, callb VisitorCallback, shards int, concu
 		token := barrier.Acquire()
 		defer barrier.Release(token)
 
-		pivotItems = append(pivotItems, nil) // start item
-		pivotPtrs := m.store.GetRangeSplitItems(shards)
+		pivotItems = append(pivotItems, startItm) // start item
+		pivotPtrs := m.store.GetRangeSplitItems(opts.Shards)
 		for _, itmPtr := range pivotPtrs {
 			itm := m.ptrToItem(itmPtr)
+			if startItm != nil && m.iterCmp(unsafe.Pointer(itm), unsafe.Pointer(startItm)) <= 0 ||
+				endItm != nil && m.iterCmp(unsafe.Pointer(itm), unsafe.Pointer(endItm)) >= 0 {
+				continue
+			}
+
 			tmpIter.Seek(itm.Bytes())
 			if tmpIter.Valid() {
 				prevItm := pivotItems[len(pivotItems)-1]
 				// Find bigger item than prev pivot
-				if prevItm == nil || m.insCmp(unsafe.Pointer(itm), unsafe.Pointer(prevItm)) > 0 {
+				if prevItm == nil || m.iterCmp(unsafe.Pointer(itm), unsafe.Pointer(prevItm)) > 0 {
 					pivotItems = append(pivotItems, itm)
 				}
 			}
 		}
-		pivotItems = append(pivotItems, nil) // end item
+		pivotItems = append(pivotItems, endItm) // end item
+
+		return nil
 	}()
 
-	errors := make([]error, len(pivotItems)-1)
+	if err != nil {
+		return err
+	}
+
+	shards := len(pivotItems) - 1
+	errors := make([]error, shards)
+	wch := make(chan int, shards)
+
+	visitShard := func(itr *Iterator, shard int) error {
+		var start, end []byte
+		var items, bytes int64
+
+		if startItem := pivotItems[shard]; startItem != nil {
+			start = startItem.Bytes()
+		}
+		if endItem := pivotItems[shard+1]; endItem != nil {
+			end = endItem.Bytes()
+		}
+
+		if opts.Progress != nil {
+			defer func() {
+				opts.Progress(shard, items, bytes)
+			}()
+		}
+
+		itr.SetRange(start, end, IncludeStart)
+		for itr.SeekFirst(); itr.Valid(); itr.Next() {
+			if items%visitorCancelCheckInterval == 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				default:
+				}
+			}
+
+			itm := (*Item)(itr.GetNode().Item())
+			if err := callb(itm, shard); err != nil {
+				return err
+			}
+
+			items++
+			bytes += int64(len(itm.Bytes()) + len(itm.Value()))
+			if opts.Progress != nil && opts.ProgressInterval > 0 &&
+				items%int64(opts.ProgressInterval) == 0 {
+				opts.Progress(shard, items, bytes)
+			}
+		}
+
+		return nil
+	}
 
 	// Run workers
-	for i := 0; i < concurrency; i++ {
+	for i := 0; i < opts.Concurrency; i++ {
 		wg.Add(1)
 		go func(wg *sync.WaitGroup) {
 			defer wg.Done()
 
-			for shard := range wch {
-				startItem := pivotItems[shard]
-				endItem := pivotItems[shard+1]
-
-				itr := m.NewIterator(snap)
-				if itr == nil {
-					panic("iterator cannot be nil")
-				}
-				defer itr.Close()
-
-				itr.SetRefreshRate(m.refreshRate)
-				if startItem == nil {
-					itr.SeekFirst()
-				} else {
-					itr.Seek(startItem.Bytes())
+			itr := m.NewIterator(snap)
+			if itr == nil {
+				for shard := range wch {
+					errors[shard] = ErrInvalidSnapshot
 				}
-			loop:
-				for ; itr.Valid(); itr.Next() {
-					if endItem != nil && m.insCmp(itr.GetNode().Item(), unsafe.Pointer(endItem)) >= 0 {
-						break loop
-					}
+				return
+			}
+			defer itr.Close()
+			itr.SetRefreshRate(m.refreshRate)
 
-					itm := (*Item)(itr.GetNode().Item())
-					if err := callb(itm, shard); err != nil {
-						errors[shard] = err
-						return
-					}
-				}
+			for shard := range wch {
+				errors[shard] = visitShard(itr, shard)
 			}
 		}(&wg)
 	}
 
 	// Provide work and wait
-	for shard := 0; shard < len(pivotItems)-1; shard++ {
+	for shard := 0; shard < shards; shard++ {
 		wch <- shard
 	}
 	close(wch)
 
 	wg.Wait()
 
-	for _, err := range errors {
+	errs := make(ShardErrors)
+	for shard, err := range errors {
 		if err != nil {
-			return err
+			errs[shard] = err
 		}
 	}
 
+	if len(errs) > 0 {
+		return errs
+	}
+
 	return nil
 }
 
+func (m *MemDB) numGCWorkers() int {
+	return len(m.gcWorkerPool)
+}
+
 func (m *MemDB) numWriters() int {
+	m.wlock.Lock()
+	defer m.wlock.Unlock()
+
 	var count int
 	for w := m.wlist; w != nil; w = w.next {
 		count++
@@ -803,29 +1694,32 @@ func (m *MemDB) changeDeltaWrState(state int,
 
 	var err error
 
-	for id, w := 0, m.wlist; w != nil; w, id = w.next, id+1 {
-		w.dwrCtx.state = state
+	m.dwrLock.Lock()
+	defer m.dwrLock.Unlock()
+
+	for id, gw := range m.gcWorkerPool {
+		gw.dwrCtx.state = state
 		if state == dwStateInit {
-			w.dwrCtx.sn = snap.sn
-			w.dwrCtx.fw = writers[id]
+			gw.dwrCtx.sn = snap.sn
+			gw.dwrCtx.fw = writers[id]
 		}
 
 		// send
 		select {
-		case w.dwrCtx.notifyStatus <- nil:
+		case gw.dwrCtx.notifyStatus <- nil:
 			break
-		case <-w.dwrCtx.closed:
+		case <-gw.dwrCtx.closed:
 			return ErrShutdown
 		}
 
 		// receive
 		select {
-		case e := <-w.dwrCtx.notifyStatus:
+		case e := <-gw.dwrCtx.notifyStatus:
 			if e != nil {
 				err = e
 			}
 			break
-		case <-w.dwrCtx.closed:
+		case <-gw.dwrCtx.closed:
 			return ErrShutdown
 		}
 	}
@@ -833,6 +1727,34 @@ func (m *MemDB) changeDeltaWrState(state int,
 	return err
 }
 
+type diskManifest struct {
+	Version int      `json:"version"`
+	Files   []string `json:"files"`
+}
+
+func writeManifest(dir string, files []string) error {
+	bs, err := json.Marshal(diskManifest{Version: diskFormatVersion, Files: files})
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, "files.json"), bs, 0660)
+}
+
+func readManifest(dir string) ([]string, error) {
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "files.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var mf diskManifest
+	if json.Unmarshal(bs, &mf) != nil || mf.Version != diskFormatVersion {
+		return nil, ErrDiskFormat
+	}
+
+	return mf.Files, nil
+}
+
 func (m *MemDB) StoreToDisk(dir string, snap *Snapshot, concurr int, itmCallback ItemCallback) (err error) {
 
 	var snapClosed bool
@@ -875,8 +1797,8 @@ func (m *MemDB) StoreToDisk(dir string, snap *Snapshot, concurr int, itmCallback
 
 	// Initialize and setup delta processing
 	if m.useDeltaFiles {
-		deltaWriters := make([]FileWriter, m.numWriters())
-		deltaFiles := make([]string, m.numWriters())
+		deltaWriters := make([]FileWriter, m.numGCWorkers())
+		deltaFiles := make([]string, m.numGCWorkers())
 		defer func() {
 			for _, w := range deltaWriters {
 				if w != nil {
@@ -887,7 +1809,7 @@ func (m *MemDB) StoreToDisk(dir string, snap *Snapshot, concurr int, itmCallback
 
 		deltadir := filepath.Join(dir, "delta")
 		os.MkdirAll(deltadir, 0755)
-		for id := 0; id < m.numWriters(); id++ {
+		for id := 0; id < m.numGCWorkers(); id++ {
 			dw := m.newFileWriter(m.fileType)
 			file := fmt.Sprintf("shard-%d", id)
 			deltafile := filepath.Join(deltadir, file)
@@ -914,8 +1836,7 @@ func (m *MemDB) StoreToDisk(dir string, snap *Snapshot, concurr int, itmCallback
 
 		defer func() {
 			if err = m.changeDeltaWrState(dwStateTerminate, nil, nil); err == nil {
-				bs, _ := json.Marshal(deltaFiles)
-				ioutil.WriteFile(filepath.Join(deltadir, "files.json"), bs, 0660)
+				err = writeManifest(deltadir, deltaFiles)
 			}
 		}()
 	}
@@ -938,8 +1859,7 @@ func (m *MemDB) StoreToDisk(dir string, snap *Snapshot, concurr int, itmCallback
 	}
 
 	if err = m.Visitor(snap, visitorCallback, shards, concurr); err == nil {
-		bs, _ := json.Marshal(files)
-		ioutil.WriteFile(filepath.Join(datadir, "files.json"), bs, 0660)
+		err = writeManifest(datadir, files)
 	}
 
 	return err
@@ -948,12 +1868,13 @@ func (m *MemDB) StoreToDisk(dir string, snap *Snapshot, concurr int, itmCallback
 func (m *MemDB) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snapshot, error) {
 	var wg sync.WaitGroup
 	datadir := filepath.Join(dir, "data")
-	var files []string
 
-	if bs, err := ioutil.ReadFile(filepath.Join(datadir, "files.json")); err != nil {
+	m.pauseAutoSnapshot()
+	defer m.resumeAutoSnapshot()
+
+	files, err := readManifest(datadir)
+	if err != nil {
 		return nil, err
-	} else {
-		json.Unmarshal(bs, &files)
 	}
 
 	var nodeCallb skiplist.NodeCallback
@@ -1035,9 +1956,9 @@ func (m *MemDB) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snap
 
 		wchan := make(chan int)
 		deltadir := filepath.Join(dir, "delta")
-		var files []string
-		if bs, err := ioutil.ReadFile(filepath.Join(deltadir, "files.json")); err == nil {
-			json.Unmarshal(bs, &files)
+		files, err := readManifest(deltadir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
 		}
 
 		readers := make([]FileReader, len(files))
@@ -1128,11 +2049,15 @@ func (m *MemDB) DumpStats() string {
 }
 
 func (m *MemDB) aggrStoreStats() skiplist.StatsReport {
+	m.wlock.Lock()
+	defer m.wlock.Unlock()
+
+	// Worker stats are merged into the store stats after every gclist
 	sts := m.store.GetStats()
 	for w := m.wlist; w != nil; w = w.next {
+		w.mu.Lock()
 		sts.Apply(&w.slSts1)
-		sts.Apply(&w.slSts2)
-		sts.Apply(&w.slSts3)
+		w.mu.Unlock()
 	}
 
 	return sts
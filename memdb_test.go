@@ -1,6 +1,10 @@
 package memdb
 
 import "fmt"
+import "bytes"
+import "context"
+import "strings"
+import "math"
 import "sync/atomic"
 import "os"
 import "testing"
@@ -9,6 +13,8 @@ import "math/rand"
 import "sync"
 import "runtime"
 import "encoding/binary"
+import "encoding/json"
+import "io/ioutil"
 import "github.com/t3rm1n4l/memdb/mm"
 
 var testConf Config
@@ -177,6 +183,1028 @@ func TestInsertDuplicates(t *testing.T) {
 	}
 }
 
+func TestSnapshotGet(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap1, _ := w.NewSnapshot()
+	defer snap1.Close()
+
+	for i := 0; i < 500; i++ {
+		w.Delete([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	for i := 0; i < 250; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap2, _ := w.NewSnapshot()
+	defer snap2.Close()
+
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("%010d", i))
+		if _, ok := snap1.Get(key); !ok {
+			t.Errorf("Expected to find %s in snap1", key)
+		}
+
+		_, ok := snap2.Get(key)
+		if expected := i < 250 || i >= 500; ok != expected {
+			t.Errorf("Expected found=%v for %s in snap2, got %v", expected, key, ok)
+		}
+	}
+
+	if _, ok := snap1.Get([]byte(fmt.Sprintf("%010d", 1000))); ok {
+		t.Errorf("Unexpected item found")
+	}
+
+	var keys [][]byte
+	for i := 200; i < 300; i++ {
+		keys = append(keys, []byte(fmt.Sprintf("%010d", i)))
+	}
+
+	_, found := snap2.MultiGet(keys)
+	for i, k := range keys {
+		if expected := i < 50; found[i] != expected {
+			t.Errorf("Expected found=%v for %s, got %v", expected, k, found[i])
+		}
+	}
+}
+
+func TestSnapshotGetAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("Buffers are not reused under the race detector")
+	}
+
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap, _ := w.NewSnapshot()
+	defer snap.Close()
+
+	key := []byte(fmt.Sprintf("%010d", 500))
+	missing := []byte(fmt.Sprintf("%010d", 1000))
+	allocs := testing.AllocsPerRun(1000, func() {
+		snap.Get(key)
+		snap.Get(missing)
+	})
+
+	if allocs != 0 {
+		t.Errorf("Expected Get to not allocate, got %v allocs", allocs)
+	}
+}
+
+func TestKeyValue(t *testing.T) {
+	os.RemoveAll("db.dump")
+	conf := DefaultConfig()
+	db := NewWithConfig(conf)
+
+	n := 10000
+	w := db.NewWriter()
+	for i := 0; i < n; i++ {
+		w.PutKV([]byte(fmt.Sprintf("%010d", i)), []byte(fmt.Sprintf("value-%d", i)))
+	}
+
+	// Items are compared only using the key
+	if w.PutKV2([]byte(fmt.Sprintf("%010d", 0)), []byte("dup")) != nil {
+		t.Errorf("Expected duplicate key insert to fail")
+	}
+
+	snap, _ := w.NewSnapshot()
+	verify := func(snap *Snapshot) {
+		i := 0
+		itr := snap.NewIterator()
+		for itr.SeekFirst(); itr.Valid(); itr.Next() {
+			if k := fmt.Sprintf("%010d", i); string(itr.Key()) != k {
+				t.Errorf("Expected key %s, got %s", k, itr.Key())
+			}
+			if v := fmt.Sprintf("value-%d", i); string(itr.Value()) != v {
+				t.Errorf("Expected value %s, got %s", v, itr.Value())
+			}
+			i++
+		}
+		itr.Close()
+
+		if i != n {
+			t.Errorf("Expected %d items, got %d", n, i)
+		}
+
+		if v, ok := snap.Get([]byte(fmt.Sprintf("%010d", 99))); !ok || string(v) != "value-99" {
+			t.Errorf("Expected value-99, got %s", v)
+		}
+	}
+
+	verify(snap)
+	if err := db.StoreToDisk("db.dump", snap, 4, nil); err != nil {
+		t.Errorf("Expected no error. got=%v", err)
+	}
+	db.Close()
+
+	db = NewWithConfig(conf)
+	defer db.Close()
+	snap, err := db.LoadFromDisk("db.dump", 4, nil)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	defer snap.Close()
+	verify(snap)
+}
+
+func TestUpdate(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	n := 1000
+	w := db.NewWriter()
+	for i := 0; i < n; i++ {
+		w.PutKV([]byte(fmt.Sprintf("%010d", i)), []byte("v1"))
+	}
+	snap1, _ := w.NewSnapshot()
+	defer snap1.Close()
+
+	for i := 0; i < n; i++ {
+		if !w.UpdateKV([]byte(fmt.Sprintf("%010d", i)), []byte("v2")) {
+			t.Errorf("Expected update to succeed for %d", i)
+		}
+	}
+
+	// Update within the same sn
+	for i := 0; i < n/2; i++ {
+		w.UpdateKV([]byte(fmt.Sprintf("%010d", i)), []byte("v3"))
+	}
+
+	if w.UpdateKV([]byte(fmt.Sprintf("%010d", n)), []byte("v2")) {
+		t.Errorf("Expected update of missing key to fail")
+	}
+
+	for i := n; i < 2*n; i++ {
+		w.UpsertKV([]byte(fmt.Sprintf("%010d", i)), []byte("v2"))
+	}
+
+	snap2, _ := w.NewSnapshot()
+	defer snap2.Close()
+
+	if c := snap2.Count(); c != int64(2*n) {
+		t.Errorf("Expected count %d, got %d", 2*n, c)
+	}
+
+	for i := 0; i < 2*n; i++ {
+		key := []byte(fmt.Sprintf("%010d", i))
+		v, ok := snap1.Get(key)
+		if i < n && string(v) != "v1" || i >= n && ok {
+			t.Errorf("Unexpected value %s for %s in snap1", v, key)
+		}
+
+		exp := "v2"
+		if i < n/2 {
+			exp = "v3"
+		}
+		if v, _ := snap2.Get(key); string(v) != exp {
+			t.Errorf("Expected %s for %s in snap2, got %s", exp, key, v)
+		}
+	}
+
+	VerifyCount(snap1, n, t)
+	VerifyCount(snap2, 2*n, t)
+}
+
+// Versions created under the same sn are kept in generation order, the
+// latest version remains the live one
+func TestUpdateSameSn(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	w.PutKV([]byte("k1"), []byte(fmt.Sprintf("%010d", 0)))
+	for i := 1; i <= 100; i++ {
+		if !w.UpdateKV([]byte("k1"), []byte(fmt.Sprintf("%010d", i))) {
+			t.Fatalf("Expected update to succeed")
+		}
+	}
+
+	snap, _ := w.NewSnapshot()
+	defer snap.Close()
+	if v, _ := snap.Get([]byte("k1")); string(v) != fmt.Sprintf("%010d", 100) {
+		t.Errorf("Expected %010d, got %s", 100, v)
+	}
+	VerifyCount(snap, 1, t)
+}
+
+func TestConcurrentUpdate(t *testing.T) {
+	var wg sync.WaitGroup
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	n := 1000
+	w := db.NewWriter()
+	for i := 0; i < n; i++ {
+		w.PutKV([]byte(fmt.Sprintf("%010d", i)), []byte("v0"))
+	}
+
+	for x := 0; x < 8; x++ {
+		wg.Add(1)
+		go func(w *Writer, id int) {
+			defer wg.Done()
+			for r := 0; r < 5; r++ {
+				for i := 0; i < n; i++ {
+					w.UpsertKV([]byte(fmt.Sprintf("%010d", i)), []byte(fmt.Sprintf("v%d", id)))
+				}
+			}
+		}(db.NewWriter(), x)
+	}
+	wg.Wait()
+
+	snap, _ := db.NewSnapshot()
+	defer snap.Close()
+	VerifyCount(snap, n, t)
+
+	if c := snap.Count(); c != int64(n) {
+		t.Errorf("Expected count %d, got %d", n, c)
+	}
+}
+
+func TestRangeIterator(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap, _ := w.NewSnapshot()
+	defer snap.Close()
+
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("%010d", i))
+	}
+
+	check := func(itr *Iterator, first, last int) {
+		count := 0
+		for ; itr.Valid(); itr.Next() {
+			if expected := string(key(first + count)); string(itr.Key()) != expected {
+				t.Errorf("Expected %s, got %s", expected, itr.Key())
+			}
+			count++
+		}
+
+		if count != last-first+1 {
+			t.Errorf("Expected %d items, got %d", last-first+1, count)
+		}
+	}
+
+	itr := snap.NewRangeIterator(key(100), key(200), IncludeBoth)
+	itr.SeekFirst()
+	check(itr, 100, 200)
+
+	itr.SetRange(key(100), key(200), 0)
+	itr.SeekFirst()
+	check(itr, 101, 199)
+
+	itr.SetRange(key(100), key(200), IncludeStart)
+	itr.Seek(key(50))
+	check(itr, 100, 199)
+
+	itr.Seek(key(150))
+	check(itr, 150, 199)
+
+	itr.SetRange(nil, key(10), IncludeEnd)
+	itr.SeekFirst()
+	check(itr, 0, 10)
+
+	itr.SetRange(key(990), nil, 0)
+	itr.SeekFirst()
+	check(itr, 991, 999)
+	itr.Close()
+}
+
+func TestReverseIterator(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("%010d", i))
+	}
+
+	w := db.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.PutKV(key(i), []byte("v1"))
+	}
+	snap1, _ := w.NewSnapshot()
+	defer snap1.Close()
+
+	for i := 0; i < 1000; i += 2 {
+		w.Delete(key(i))
+	}
+	for i := 1; i < 1000; i += 2 {
+		w.UpdateKV(key(i), []byte("v2"))
+	}
+	snap2, _ := w.NewSnapshot()
+	defer snap2.Close()
+
+	itr := snap1.NewIterator()
+	i := 999
+	for itr.SeekLast(); itr.Valid(); itr.Prev() {
+		if string(itr.Key()) != string(key(i)) || string(itr.Value()) != "v1" {
+			t.Errorf("Expected %s, got %s", key(i), itr.Key())
+		}
+		i--
+	}
+	itr.Close()
+
+	if i != -1 {
+		t.Errorf("Expected to visit all items, stopped at %d", i)
+	}
+
+	itr = snap2.NewIterator()
+	i = 999
+	for itr.SeekLast(); itr.Valid(); itr.Prev() {
+		if string(itr.Key()) != string(key(i)) || string(itr.Value()) != "v2" {
+			t.Errorf("Expected %s, got %s", key(i), itr.Key())
+		}
+		i -= 2
+	}
+
+	itr.SeekForPrev(key(500))
+	if string(itr.Key()) != string(key(499)) {
+		t.Errorf("Expected %s, got %s", key(499), itr.Key())
+	}
+
+	// Change direction
+	itr.Next()
+	if string(itr.Key()) != string(key(501)) {
+		t.Errorf("Expected %s, got %s", key(501), itr.Key())
+	}
+	itr.Prev()
+	if string(itr.Key()) != string(key(499)) {
+		t.Errorf("Expected %s, got %s", key(499), itr.Key())
+	}
+
+	itr.SetRange(key(100), key(200), 0)
+	count := 0
+	for itr.SeekLast(); itr.Valid(); itr.Prev() {
+		if count == 0 && string(itr.Key()) != string(key(199)) {
+			t.Errorf("Expected %s, got %s", key(199), itr.Key())
+		}
+		count++
+	}
+
+	if count != 50 {
+		t.Errorf("Expected 50 items, got %d", count)
+	}
+
+	itr.SetRefreshRate(10)
+	itr.SetRange(key(100), key(200), IncludeBoth)
+	count = 0
+	for itr.SeekForPrev(key(300)); itr.Valid(); itr.Prev() {
+		count++
+	}
+	itr.Close()
+
+	if count != 50 {
+		t.Errorf("Expected 50 items, got %d", count)
+	}
+}
+
+func TestPrefixIterator(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 100; j++ {
+			w.Put([]byte(fmt.Sprintf("sec%d\x00%05d", i, j)))
+		}
+	}
+	w.Put([]byte("sec\xff\xff"))
+	w.Put([]byte("sed"))
+
+	snap, _ := w.NewSnapshot()
+	defer snap.Close()
+
+	count := func(prefix string) int {
+		var c int
+		itr := snap.NewPrefixIterator([]byte(prefix))
+		for itr.SeekFirst(); itr.Valid(); itr.Next() {
+			if !strings.HasPrefix(string(itr.Key()), prefix) {
+				t.Errorf("Unexpected key %s for prefix %s", itr.Key(), prefix)
+			}
+			c++
+		}
+		itr.Close()
+		return c
+	}
+
+	if c := count("sec5\x00"); c != 100 {
+		t.Errorf("Expected 100 items, got %d", c)
+	}
+
+	if c := count("sec5\x00000"); c != 100 {
+		t.Errorf("Expected 100 items, got %d", c)
+	}
+
+	if c := count("sec"); c != 1001 {
+		t.Errorf("Expected 1001 items, got %d", c)
+	}
+
+	if c := count("sec\xff"); c != 1 {
+		t.Errorf("Expected 1 item, got %d", c)
+	}
+
+	if c := count("sez"); c != 0 {
+		t.Errorf("Expected 0 items, got %d", c)
+	}
+}
+
+func TestPrefixIteratorCustomSuccessor(t *testing.T) {
+	// Keys are ordered by the length of the secondary part first
+	secLen := func(k []byte) int {
+		if l := bytes.IndexByte(k, 0); l >= 0 {
+			return l
+		}
+		return len(k)
+	}
+
+	conf := DefaultConfig()
+	conf.SetKeyComparator(func(a, b []byte) int {
+		if la, lb := secLen(a), secLen(b); la != lb {
+			return la - lb
+		}
+		return bytes.Compare(a, b)
+	})
+
+	conf.SetPrefixSuccessor(func(prefix []byte) []byte {
+		succ := append([]byte(nil), prefix...)
+		succ[len(succ)-2]++
+		return succ
+	})
+
+	db := NewWithConfig(conf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for _, sec := range []string{"a", "b", "aa", "ab", "bbb"} {
+		for j := 0; j < 10; j++ {
+			w.Put([]byte(fmt.Sprintf("%s\x00%05d", sec, j)))
+		}
+	}
+
+	snap, _ := w.NewSnapshot()
+	defer snap.Close()
+
+	var c int
+	itr := snap.NewPrefixIterator([]byte("aa\x00"))
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		if !bytes.HasPrefix(itr.Key(), []byte("aa\x00")) {
+			t.Errorf("Unexpected key %s", itr.Key())
+		}
+		c++
+	}
+	itr.Close()
+
+	if c != 10 {
+		t.Errorf("Expected 10 items, got %d", c)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	w.Put([]byte("k1"))
+
+	b := NewBatch()
+	b.Put([]byte("k1"))
+	b.Put([]byte("k2"))
+	b.PutKV([]byte("k3"), []byte("v1"))
+	b.Delete([]byte("k4"))
+	b.Delete([]byte("k1"))
+	b.UpdateKV([]byte("k3"), []byte("v2"))
+	b.UpdateKV([]byte("k5"), []byte("v2"))
+	b.Update([]byte("k2"), []byte("k3"))
+
+	exp := []bool{false, true, true, false, true, true, false, false}
+	for i, ok := range w.Apply(b) {
+		if ok != exp[i] {
+			t.Errorf("Expected %v for op %d, got %v", exp[i], i, ok)
+		}
+	}
+
+	snap, _ := w.NewSnapshot()
+	defer snap.Close()
+
+	if _, ok := snap.Get([]byte("k1")); ok {
+		t.Errorf("Expected k1 to be deleted")
+	}
+
+	if v, _ := snap.Get([]byte("k3")); string(v) != "v2" {
+		t.Errorf("Expected v2 for k3, got %s", v)
+	}
+
+	VerifyCount(snap, 2, t)
+}
+
+func TestConcurrentBatch(t *testing.T) {
+	var wg sync.WaitGroup
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	n := 10000
+	w := db.NewWriter()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		b := NewBatch()
+		for i := 0; i < n; i++ {
+			b.Reset()
+			b.Put([]byte(fmt.Sprintf("p%010d", i)))
+			b.Put([]byte(fmt.Sprintf("b%010d", i)))
+			if i%2 == 1 {
+				b.Delete([]byte(fmt.Sprintf("p%010d", i-1)))
+				b.Delete([]byte(fmt.Sprintf("b%010d", i-1)))
+			}
+			w.Apply(b)
+		}
+	}()
+
+	count := func(snap *Snapshot, prefix string) int {
+		var c int
+		itr := snap.NewPrefixIterator([]byte(prefix))
+		for itr.SeekFirst(); itr.Valid(); itr.Next() {
+			c++
+		}
+		itr.Close()
+		return c
+	}
+
+	for i := 0; i < 100; i++ {
+		snap, _ := db.NewSnapshot()
+		if c1, c2 := count(snap, "p"), count(snap, "b"); c1 != c2 {
+			t.Errorf("Expected matching counts, got %d and %d", c1, c2)
+		}
+		snap.Close()
+	}
+
+	wg.Wait()
+}
+
+func TestConditionalWrites(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	if _, ok := w.PutIfAbsent([]byte("k1"), []byte("v1")); !ok {
+		t.Errorf("Expected PutIfAbsent to succeed")
+	}
+
+	if v, ok := w.PutIfAbsent([]byte("k1"), []byte("v2")); ok || string(v) != "v1" {
+		t.Errorf("Expected PutIfAbsent to fail with v1, got %v %s", ok, v)
+	}
+
+	if w.CompareAndSwap([]byte("k1"), []byte("v2"), []byte("v3")) {
+		t.Errorf("Expected CompareAndSwap to fail")
+	}
+
+	snap1, _ := w.NewSnapshot()
+	defer snap1.Close()
+
+	if !w.CompareAndSwap([]byte("k1"), []byte("v1"), []byte("v3")) {
+		t.Errorf("Expected CompareAndSwap to succeed")
+	}
+
+	if w.CompareAndSwap([]byte("k2"), nil, []byte("v3")) {
+		t.Errorf("Expected CompareAndSwap of missing key to fail")
+	}
+
+	if w.DeleteIf([]byte("k1"), []byte("v1")) {
+		t.Errorf("Expected DeleteIf to fail")
+	}
+
+	snap2, _ := w.NewSnapshot()
+	defer snap2.Close()
+
+	if !w.DeleteIf([]byte("k1"), []byte("v3")) {
+		t.Errorf("Expected DeleteIf to succeed")
+	}
+
+	snap3, _ := w.NewSnapshot()
+	defer snap3.Close()
+
+	if v, _ := snap1.Get([]byte("k1")); string(v) != "v1" {
+		t.Errorf("Expected v1, got %s", v)
+	}
+
+	if v, _ := snap2.Get([]byte("k1")); string(v) != "v3" {
+		t.Errorf("Expected v3, got %s", v)
+	}
+
+	if _, ok := snap3.Get([]byte("k1")); ok {
+		t.Errorf("Expected k1 to be deleted")
+	}
+}
+
+func TestConcurrentCompareAndSwap(t *testing.T) {
+	var wg sync.WaitGroup
+	var inserts int64
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	nw, n := 8, 1000
+	for i := 0; i < nw; i++ {
+		wg.Add(1)
+		go func(w *Writer) {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				if _, ok := w.PutIfAbsent([]byte(fmt.Sprintf("%010d", j)), nil); ok {
+					atomic.AddInt64(&inserts, 1)
+				}
+
+				for {
+					v, _ := w.PutIfAbsent([]byte("counter"), []byte(fmt.Sprintf("%010d", 0)))
+					var c int
+					fmt.Sscanf(string(v), "%d", &c)
+					if v == nil || w.CompareAndSwap([]byte("counter"), v,
+						[]byte(fmt.Sprintf("%010d", c+1))) {
+						break
+					}
+				}
+			}
+		}(db.NewWriter())
+	}
+	wg.Wait()
+
+	if inserts != int64(n) {
+		t.Errorf("Expected %d inserts, got %d", n, inserts)
+	}
+
+	snap, _ := db.NewSnapshot()
+	defer snap.Close()
+
+	exp := fmt.Sprintf("%010d", nw*n-1)
+	if v, _ := snap.Get([]byte("counter")); string(v) != exp {
+		t.Errorf("Expected counter %s, got %s", exp, v)
+	}
+
+	VerifyCount(snap, n+1, t)
+}
+
+// Replacements within the same sn should never leave the key missing for a
+// concurrent reader
+func TestSameSnReplaceVisibility(t *testing.T) {
+	var wg sync.WaitGroup
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	key := []byte("key")
+	w := db.NewWriter()
+	w.PutKV(key, []byte(fmt.Sprintf("%010d", 0)))
+	sn := db.getCurrSn()
+
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r := db.NewWriter()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			if v, ok := r.PutIfAbsent(key, nil); ok || v == nil {
+				t.Errorf("Expected key to be live")
+				return
+			}
+		}
+	}()
+
+	n := 10000
+	for i := 0; i < n; i++ {
+		v := []byte(fmt.Sprintf("%010d", i+1))
+		if i%2 == 0 {
+			if !w.UpdateKV(key, v) {
+				t.Fatalf("Expected update to succeed")
+			}
+		} else if !w.CompareAndSwap(key, []byte(fmt.Sprintf("%010d", i)), v) {
+			t.Fatalf("Expected compare and swap to succeed")
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	if db.getCurrSn() != sn {
+		t.Errorf("Expected all replacements under sn %d", sn)
+	}
+
+	snap, _ := db.NewSnapshot()
+	defer snap.Close()
+	if v, _ := snap.Get(key); string(v) != fmt.Sprintf("%010d", n) {
+		t.Errorf("Expected %010d, got %s", n, v)
+	}
+	VerifyCount(snap, 1, t)
+}
+
+func TestSnapshotBeyond32BitSn(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	db.currSn = math.MaxUint32 - 2
+	db.lastGCSn = db.currSn - 1
+
+	var snaps []*Snapshot
+	w := db.NewWriter()
+	for i := 0; i < 5; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+		if i > 0 {
+			w.Delete([]byte(fmt.Sprintf("%010d", i-1)))
+		}
+
+		snap, err := w.NewSnapshot()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		snaps = append(snaps, snap)
+	}
+
+	for i, snap := range snaps {
+		VerifyCount(snap, 1, t)
+		if _, ok := snap.Get([]byte(fmt.Sprintf("%010d", i))); !ok {
+			t.Errorf("Expected %d to be visible in snapshot %d", i, snap.sn)
+		}
+	}
+
+	// Snapshots should be collected in sn order
+	for _, snap := range snaps {
+		snap.Close()
+	}
+
+	if db.lastGCSn != math.MaxUint32+2 {
+		t.Errorf("Expected lastGCSn %d, got %d", uint64(math.MaxUint32+2), db.lastGCSn)
+	}
+}
+
+func TestSnapshotEncodeDecode(t *testing.T) {
+	var b bytes.Buffer
+	buf := make([]byte, 12)
+
+	snap := &Snapshot{sn: math.MaxUint32 + 10}
+	if err := snap.Encode(buf, &b); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var snap2 Snapshot
+	if err := snap2.Decode(buf, &b); err != nil || snap2.sn != snap.sn {
+		t.Errorf("Expected sn %d, got %d (%v)", snap.sn, snap2.sn, err)
+	}
+
+	// Older 4 byte encoding
+	b.Write([]byte{0, 0, 1, 0})
+	if err := snap2.Decode(buf, &b); err != nil || snap2.sn != 256 {
+		t.Errorf("Expected sn 256, got %d (%v)", snap2.sn, err)
+	}
+}
+
+func TestWriterClose(t *testing.T) {
+	var wg sync.WaitGroup
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	nw, n := 8, 1000
+	snap1, _ := db.NewSnapshot()
+
+	writers := make([]*Writer, nw)
+	for i := 0; i < nw; i++ {
+		writers[i] = db.NewWriter()
+		wg.Add(1)
+		go func(id int, w *Writer) {
+			defer wg.Done()
+			defer w.Close()
+
+			for j := 0; j < n; j++ {
+				w.Put([]byte(fmt.Sprintf("%d-%010d", id, j)))
+			}
+
+			for j := 0; j < n/2; j++ {
+				w.Delete([]byte(fmt.Sprintf("%d-%010d", id, j)))
+			}
+		}(i, writers[i])
+	}
+	wg.Wait()
+
+	if c := db.numWriters(); c != 0 {
+		t.Errorf("Expected no writers, got %d writers", c)
+	}
+
+	// Pending state is handed over to the DB
+	for _, w := range writers {
+		if w.gchead != nil || w.gclen != 0 || w.count != 0 {
+			t.Errorf("Expected writer state to be handed over, got gclen %d count %d", w.gclen, w.count)
+		}
+	}
+
+	snap2, _ := db.NewSnapshot()
+	VerifyCount(snap2, nw*n/2, t)
+	if c := snap2.Count(); c != int64(nw*n/2) {
+		t.Errorf("Expected count %d, got %d", nw*n/2, c)
+	}
+
+	snap1.Close()
+	snap2.Close()
+
+	// Deleted items of the closed writers should be reclaimed
+	snap3, _ := db.NewSnapshot()
+	snap3.Close()
+	for i := 0; i < 100; i++ {
+		if db.store.GetStats().NodeCount == nw*n/2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if c := db.store.GetStats().NodeCount; c != nw*n/2 {
+		t.Errorf("Expected %d nodes after GC, got %d", nw*n/2, c)
+	}
+}
+
+func TestGCWorkerPool(t *testing.T) {
+	os.RemoveAll("db.dump")
+	db1 := NewWithConfig(testConf)
+	db1.NewWriter()
+	if c := db1.numGCWorkers(); c != defaultGCWorkers {
+		t.Errorf("Expected %d GC workers by default, got %d", defaultGCWorkers, c)
+	}
+	db1.Close()
+
+	conf := testConf
+	conf.SetGCWorkers(2)
+	conf.SetFreeWorkers(1)
+	db := NewWithConfig(conf)
+	defer db.Close()
+
+	nw, n := 64, 100
+	var writers []*Writer
+	for i := 0; i < nw; i++ {
+		writers = append(writers, db.NewWriter())
+	}
+
+	// Workers are shared by the writers
+	if c := db.numGCWorkers(); c != 2 {
+		t.Errorf("Expected 2 GC workers, got %d", c)
+	}
+
+	for i, w := range writers {
+		for j := 0; j < n; j++ {
+			w.Put([]byte(fmt.Sprintf("%d-%010d", i, j)))
+		}
+	}
+
+	snap1, _ := db.NewSnapshot()
+	snap1.Close()
+
+	sn := db.getCurrSn()
+	for i, w := range writers {
+		for j := 0; j < n/2; j++ {
+			w.Delete([]byte(fmt.Sprintf("%d-%010d", i, j)))
+		}
+	}
+
+	snap2, _ := db.NewSnapshot()
+	if err := db.StoreToDisk("db.dump", snap2, 4, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	files, _ := readManifest("db.dump/delta")
+	if len(files) != 2 {
+		t.Errorf("Expected a delta file per GC worker, got %v", files)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.WaitForGC(ctx, sn); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if c := db.store.GetStats().NodeCount; c != nw*n/2 {
+		t.Errorf("Expected %d nodes after GC, got %d", nw*n/2, c)
+	}
+}
+
+func TestConcurrentSnapshotCreation(t *testing.T) {
+	var wg sync.WaitGroup
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	nw, n := 4, 10000
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			w := db.NewWriter()
+			snap, _ := db.NewSnapshot()
+			if c := CountItems(snap); int64(c) != snap.Count() {
+				t.Errorf("Snapshot count mismatch. Expected %d, got %d", c, snap.Count())
+			}
+			snap.Close()
+			w.Close()
+		}
+	}()
+
+	for i := 0; i < nw; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			w := db.NewWriter()
+			for j := 0; j < n; j++ {
+				w.Put([]byte(fmt.Sprintf("%d-%010d", id, j)))
+				if j%2 == 0 {
+					w.Delete([]byte(fmt.Sprintf("%d-%010d", id, j)))
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+	<-done
+
+	snap, _ := db.NewSnapshot()
+	defer snap.Close()
+	VerifyCount(snap, nw*n/2, t)
+	if c := snap.Count(); c != int64(nw*n/2) {
+		t.Errorf("Expected count %d, got %d", nw*n/2, c)
+	}
+}
+
+func TestCloseWithContext(t *testing.T) {
+	db := NewWithConfig(testConf)
+	w := db.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	snap, _ := db.NewSnapshot()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		snap.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if snaps, err := db.CloseWithContext(ctx); err != nil || len(snaps) != 0 {
+		t.Errorf("Expected clean shutdown, got %v (%d snapshots)", err, len(snaps))
+	}
+
+	db = NewWithConfig(testConf)
+	w = db.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	snap, _ = db.NewSnapshot()
+	itr := snap.NewIterator()
+	itr.SeekFirst()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	snaps, err := db.CloseWithContext(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+
+	if len(snaps) != 1 || snaps[0] != snap {
+		t.Errorf("Expected the open snapshot to be reported, got %v", snaps)
+	}
+
+	if itr.Valid() {
+		t.Errorf("Expected iterator to be invalidated")
+	}
+
+	if _, ok := snap.Get([]byte(fmt.Sprintf("%010d", 0))); ok {
+		t.Errorf("Expected lookup on invalidated snapshot to fail")
+	}
+
+	if snap.NewIterator() != nil {
+		t.Errorf("Expected no iterator on invalidated snapshot")
+	}
+
+	itr.Close()
+	snap.Close()
+}
+
 func TestGetPerf(t *testing.T) {
 	var wg sync.WaitGroup
 	db := NewWithConfig(testConf)
@@ -297,6 +1325,92 @@ func TestStoreDiskShutdown(t *testing.T) {
 	}
 }
 
+// Background workers should not observe the store being replaced by
+// LoadFromDisk
+func TestLoadDiskThenWrite(t *testing.T) {
+	os.RemoveAll("db.dump")
+	conf := testConf
+	conf.SetExpiryInterval(time.Millisecond)
+	db := NewWithConfig(conf)
+	w := db.NewWriter()
+	n := 1000
+	for i := 0; i < n; i++ {
+		w.PutKV([]byte(fmt.Sprintf("%010d", i)), []byte("v"))
+	}
+
+	snap, _ := db.NewSnapshot()
+	if err := db.StoreToDisk("db.dump", snap, 4, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	db.Close()
+
+	db = NewWithConfig(conf)
+	defer db.Close()
+	snap, err := db.LoadFromDisk("db.dump", 4, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	snap.Close()
+
+	w = db.NewWriter()
+	for i := 0; i < n; i++ {
+		w.Delete([]byte(fmt.Sprintf("%010d", i)))
+		w.PutWithTTL([]byte(fmt.Sprintf("%010d", i+n)), time.Hour)
+	}
+
+	snap, _ = db.NewSnapshot()
+	sn := snap.sn
+	snap.Close()
+	snap, _ = db.NewSnapshot()
+	defer snap.Close()
+	VerifyCount(snap, n, t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.WaitForGC(ctx, sn); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if c := db.store.GetStats().NodeCount; c != n {
+		t.Errorf("Expected %d nodes after GC, got %d", n, c)
+	}
+}
+
+func TestLoadDiskFormatVersion(t *testing.T) {
+	os.RemoveAll("db.dump")
+	db := NewWithConfig(testConf)
+	defer db.Close()
+	w := db.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.PutKV([]byte(fmt.Sprintf("%010d", i)), []byte("v"))
+	}
+
+	snap, _ := db.NewSnapshot()
+	if err := db.StoreToDisk("db.dump", snap, 4, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	files, err := readManifest("db.dump/data")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Layout written before the format was versioned
+	bs, _ := json.Marshal(files)
+	ioutil.WriteFile("db.dump/data/files.json", bs, 0660)
+	db2 := NewWithConfig(testConf)
+	defer db2.Close()
+	if _, err := db2.LoadFromDisk("db.dump", 4, nil); err != ErrDiskFormat {
+		t.Errorf("Expected ErrDiskFormat, got %v", err)
+	}
+
+	bs, _ = json.Marshal(diskManifest{Version: diskFormatVersion + 1, Files: files})
+	ioutil.WriteFile("db.dump/data/files.json", bs, 0660)
+	if _, err := db2.LoadFromDisk("db.dump", 4, nil); err != ErrDiskFormat {
+		t.Errorf("Expected ErrDiskFormat, got %v", err)
+	}
+}
+
 func TestDelete(t *testing.T) {
 	expected := 10
 	db := NewWithConfig(testConf)
@@ -521,6 +1635,88 @@ func TestVisitorError(t *testing.T) {
 	}
 }
 
+func TestVisitorWithOptions(t *testing.T) {
+	const n = 100000
+	var wg sync.WaitGroup
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	wg.Add(1)
+	doInsert(db, &wg, n, false, false)
+	snap, _ := db.NewSnapshot()
+	defer snap.Close()
+
+	start, end := make([]byte, 8), make([]byte, 8)
+	binary.BigEndian.PutUint64(start, 20000)
+	binary.BigEndian.PutUint64(end, 70000)
+
+	var count, progressItems, progressBytes int64
+	var mu sync.Mutex
+	shardItems := make(map[int]int64)
+	shardBytes := make(map[int]int64)
+	opts := VisitorOptions{
+		Shards:      8,
+		Concurrency: 4,
+		Start:       start,
+		End:         end,
+		Progress: func(shard int, items, bytes int64) {
+			mu.Lock()
+			defer mu.Unlock()
+			if items < shardItems[shard] {
+				t.Errorf("Progress of shard %d went backwards", shard)
+			}
+			shardItems[shard] = items
+			shardBytes[shard] = bytes
+		},
+		ProgressInterval: 1000,
+	}
+
+	callb := func(itm *Item, shard int) error {
+		v := binary.BigEndian.Uint64(itm.Bytes())
+		if v < 20000 || v >= 70000 {
+			t.Errorf("Unexpected item %d outside the range", v)
+		}
+		atomic.AddInt64(&count, 1)
+		return nil
+	}
+
+	if err := db.VisitorWithOptions(context.Background(), snap, callb, opts); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	for shard := range shardItems {
+		progressItems += shardItems[shard]
+		progressBytes += shardBytes[shard]
+	}
+
+	if count != 50000 || progressItems != 50000 || progressBytes != 50000*8 {
+		t.Errorf("Expected 50000 items, got %d (progress %d, %d bytes)", count, progressItems, progressBytes)
+	}
+
+	// Cancellation is reported by every shard which did not complete
+	ctx, cancel := context.WithCancel(context.Background())
+	callb = func(itm *Item, shard int) error {
+		cancel()
+		return nil
+	}
+
+	err := db.VisitorWithOptions(ctx, snap, callb, VisitorOptions{Shards: 8, Concurrency: 2})
+	errs, ok := err.(ShardErrors)
+	if !ok || len(errs) == 0 {
+		t.Fatalf("Expected shard errors, got %v", err)
+	}
+
+	for shard, err := range errs {
+		if err != context.Canceled {
+			t.Errorf("Expected cancellation of shard %d, got %v", shard, err)
+		}
+	}
+
+	if err := db.VisitorWithOptions(context.Background(), nil, callb, opts); err != ErrInvalidSnapshot {
+		t.Errorf("Expected invalid snapshot, got %v", err)
+	}
+}
+
 func doUpdate(db *MemDB, wg *sync.WaitGroup, w *Writer, start, end int, version int) {
 	defer wg.Done()
 	for ; start < end; start++ {
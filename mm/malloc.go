@@ -55,6 +55,22 @@ func Stats() string {
 	return s
 }
 
+type AllocStats struct {
+	Mallocs  uint64 `json:"mallocs"`
+	Frees    uint64 `json:"frees"`
+	Resident uint64 `json:"resident"`
+}
+
+// AllocatorStats returns the allocator counters. Mallocs and frees are
+// counted only in debug mode.
+func AllocatorStats() AllocStats {
+	return AllocStats{
+		Mallocs:  atomic.LoadUint64(&stats.allocs),
+		Frees:    atomic.LoadUint64(&stats.frees),
+		Resident: Size(),
+	}
+}
+
 func Size() uint64 {
 	return uint64(C.mm_size())
 }
@@ -86,6 +86,22 @@ func (nt *NodeTable) Stats() string {
 		nt.fastHTCount, nt.slowHTCount, nt.conflicts, nt.MemoryInUse())
 }
 
+type TableStats struct {
+	FastHTCount uint64 `json:"fast_ht_count"`
+	SlowHTCount uint64 `json:"slow_ht_count"`
+	Conflicts   uint64 `json:"conflicts"`
+	MemoryInUse int64  `json:"memory_in_use"`
+}
+
+func (nt *NodeTable) GetStats() TableStats {
+	return TableStats{
+		FastHTCount: nt.fastHTCount,
+		SlowHTCount: nt.slowHTCount,
+		Conflicts:   nt.conflicts,
+		MemoryInUse: nt.MemoryInUse(),
+	}
+}
+
 func (nt *NodeTable) MemoryInUse() int64 {
 	return int64(approxItemSize * (nt.fastHTCount + nt.slowHTCount))
 }
@@ -0,0 +1,191 @@
+package memdb
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/t3rm1n4l/memdb/skiplist"
+)
+
+var ErrMemoryQuotaExceeded = fmt.Errorf("Memory quota exceeded")
+
+type QuotaPolicy int
+
+const (
+	// Writes fail while the quota is exceeded
+	QuotaReject QuotaPolicy = iota
+	// Writers wait until enough memory is reclaimed
+	QuotaBlock
+)
+
+// Writers account allocations in local stats. The stats are published and
+// the quota is checked every time a writer allocates this many bytes.
+const quotaCheckBytes = 1 << 20
+
+var (
+	processQuota       int64
+	processQuotaPolicy QuotaPolicy
+
+	reclaimLock sync.Mutex
+	reclaimCh   = make(chan struct{})
+)
+
+// SetProcessMemoryQuota limits the total memory used by all the MemDB
+// instances. A zero value removes the limit.
+func SetProcessMemoryQuota(bytes int64, policy QuotaPolicy) {
+	reclaimLock.Lock()
+	processQuotaPolicy = policy
+	reclaimLock.Unlock()
+	atomic.StoreInt64(&processQuota, bytes)
+	notifyReclaim()
+}
+
+func reclaimNotifier() <-chan struct{} {
+	reclaimLock.Lock()
+	defer reclaimLock.Unlock()
+
+	return reclaimCh
+}
+
+// Wake up the writers waiting for memory
+func notifyReclaim() {
+	reclaimLock.Lock()
+	defer reclaimLock.Unlock()
+
+	close(reclaimCh)
+	reclaimCh = make(chan struct{})
+}
+
+func (m *MemDB) hasQuota() bool {
+	return m.quota > 0 || atomic.LoadInt64(&processQuota) > 0
+}
+
+// Memory usage as per the published stats
+func (m *MemDB) quotaUsage() int64 {
+	return m.store.MemoryInUse() + m.snapshots.MemoryInUse() + m.gcsnapshots.MemoryInUse()
+}
+
+func processQuotaUsage() (sz int64) {
+	buf := dbInstances.MakeBuf()
+	defer dbInstances.FreeBuf(buf)
+	iter := dbInstances.NewIterator(CompareMemDB, buf)
+	defer iter.Close()
+
+	for iter.SeekFirst(); iter.Valid(); iter.Next() {
+		sz += (*MemDB)(iter.Get()).quotaUsage()
+	}
+
+	return
+}
+
+// Returns the policy of the quota which is exceeded
+func (m *MemDB) exceededQuota() (QuotaPolicy, bool) {
+	if m.quota > 0 && m.quotaUsage() >= m.quota {
+		return m.quotaPolicy, true
+	}
+
+	if q := atomic.LoadInt64(&processQuota); q > 0 && processQuotaUsage() >= q {
+		reclaimLock.Lock()
+		defer reclaimLock.Unlock()
+		return processQuotaPolicy, true
+	}
+
+	return 0, false
+}
+
+// Called before a write which allocates memory, without holding the writer
+// mutex so that snapshots can be created while the writer is blocked
+func (w *Writer) checkQuota() error {
+	if atomic.LoadInt32(&w.overQuota) == 0 {
+		return nil
+	}
+
+	for {
+		ch := reclaimNotifier()
+		policy, over := w.exceededQuota()
+		if !over {
+			atomic.StoreInt32(&w.overQuota, 0)
+			return nil
+		}
+
+		if policy == QuotaReject {
+			return ErrMemoryQuotaExceeded
+		}
+
+		select {
+		case <-ch:
+		case <-w.shutdownCh:
+			return ErrShutdown
+		}
+	}
+}
+
+// Called with the writer mutex held after memory is allocated
+func (w *Writer) trackQuota() {
+	if !w.hasQuota() {
+		return
+	}
+
+	limit := int64(quotaCheckBytes)
+	if w.quota > 0 && w.quota/16 < limit {
+		limit = w.quota / 16
+	}
+
+	if w.slSts1.MemoryInUse() >= limit {
+		w.store.Stats.Merge(&w.slSts1)
+		if _, over := w.exceededQuota(); over {
+			atomic.StoreInt32(&w.overQuota, 1)
+		}
+	}
+}
+
+// TryPut is Put2 which fails with ErrMemoryQuotaExceeded if the write is
+// rejected by the memory quota. A nil node without an error is returned if
+// the key already exists.
+func (w *Writer) TryPut(bs []byte) (*skiplist.Node, error) {
+	return w.TryPutKV(bs, nil)
+}
+
+func (w *Writer) TryPutKV(key, val []byte) (*skiplist.Node, error) {
+	if err := w.checkQuota(); err != nil {
+		return nil, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.put(key, val, w.getCurrSn()), nil
+}
+
+// Conditional writes which report ErrMemoryQuotaExceeded if the write is
+// rejected by the memory quota, instead of failing as if the condition
+// was not met.
+
+func (w *Writer) TryUpdate(old, new []byte) (bool, error) {
+	if w.keyCmp(old, new) != 0 {
+		return false, nil
+	}
+
+	return w.update(w.newItem(new, nil, w.useMemoryMgmt), false, nil)
+}
+
+func (w *Writer) TryUpdateKV(key, val []byte) (bool, error) {
+	return w.update(w.newItem(key, val, w.useMemoryMgmt), false, nil)
+}
+
+func (w *Writer) TryUpsert(bs []byte) (bool, error) {
+	return w.update(w.newItem(bs, nil, w.useMemoryMgmt), true, nil)
+}
+
+func (w *Writer) TryUpsertKV(key, val []byte) (bool, error) {
+	return w.update(w.newItem(key, val, w.useMemoryMgmt), true, nil)
+}
+
+func (w *Writer) TryPutIfAbsent(key, val []byte) ([]byte, bool, error) {
+	return w.putIfAbsent(key, val)
+}
+
+func (w *Writer) TryCompareAndSwap(key, old, new []byte) (bool, error) {
+	return w.compareAndSwap(key, old, new)
+}
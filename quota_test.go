@@ -0,0 +1,220 @@
+package memdb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMemoryQuotaReject(t *testing.T) {
+	quota := int64(4 << 20)
+	conf := testConf
+	conf.SetMemoryQuota(quota, QuotaReject)
+	db := NewWithConfig(conf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	n := 0
+	for ; n < 1000000; n++ {
+		if _, err := w.TryPutKV([]byte(fmt.Sprintf("%010d", n)), make([]byte, 100)); err != nil {
+			if err != ErrMemoryQuotaExceeded {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			break
+		}
+	}
+
+	if n == 1000000 {
+		t.Fatalf("Expected writes to be rejected")
+	}
+
+	if used := db.MemoryInUse(); used < quota || used > quota+quota/16+(1<<10) {
+		t.Errorf("Unexpected memory usage %d for quota %d", used, quota)
+	}
+
+	if w.PutKV2([]byte("x"), nil) != nil || w.UpsertKV([]byte("x"), nil) {
+		t.Errorf("Expected writes to fail")
+	}
+
+	key := []byte(fmt.Sprintf("%010d", 0))
+	if _, _, err := w.TryPutIfAbsent([]byte("x"), nil); err != ErrMemoryQuotaExceeded {
+		t.Errorf("Expected ErrMemoryQuotaExceeded, got %v", err)
+	}
+
+	if _, err := w.TryUpdateKV(key, nil); err != ErrMemoryQuotaExceeded {
+		t.Errorf("Expected ErrMemoryQuotaExceeded, got %v", err)
+	}
+
+	if _, err := w.TryUpsertKV(key, nil); err != ErrMemoryQuotaExceeded {
+		t.Errorf("Expected ErrMemoryQuotaExceeded, got %v", err)
+	}
+
+	if _, err := w.TryCompareAndSwap(key, make([]byte, 100), nil); err != ErrMemoryQuotaExceeded {
+		t.Errorf("Expected ErrMemoryQuotaExceeded, got %v", err)
+	}
+
+	if _, err := w.TryPutKVWithTTL([]byte("x"), nil, time.Hour); err != ErrMemoryQuotaExceeded {
+		t.Errorf("Expected ErrMemoryQuotaExceeded, got %v", err)
+	}
+
+	// Deletes are never rejected
+	if !w.DeleteIf(key, make([]byte, 100)) {
+		t.Errorf("Expected delete to succeed")
+	}
+
+	// Writes are accepted after the memory is reclaimed
+	for i := 0; i < n; i++ {
+		w.Delete([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap, _ := w.NewSnapshot()
+	snap.Close()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, err := w.TryPut([]byte("x")); err == nil {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected writes to be accepted after GC")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMemoryQuotaBlock(t *testing.T) {
+	quota := int64(4 << 20)
+	conf := testConf
+	conf.SetMemoryQuota(quota, QuotaBlock)
+	db := NewWithConfig(conf)
+	defer db.Close()
+
+	w1 := db.NewWriter()
+	w2 := db.NewWriter()
+
+	// Fill up most of the quota
+	n := 0
+	for ; db.MemoryInUse() < quota*3/4; n++ {
+		w1.PutKV([]byte(fmt.Sprintf("a-%010d", n)), make([]byte, 100))
+	}
+
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < n; i++ {
+			w2.PutKV([]byte(fmt.Sprintf("b-%010d", i)), make([]byte, 100))
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatalf("Expected writer to be blocked")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	for i := 0; i < n; i++ {
+		w1.Delete([]byte(fmt.Sprintf("a-%010d", i)))
+	}
+	snap, _ := w1.NewSnapshot()
+	snap.Close()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Expected writer to be unblocked")
+	}
+
+	snap, _ = w1.NewSnapshot()
+	defer snap.Close()
+	VerifyCount(snap, n, t)
+}
+
+func TestMemoryQuotaBlockShutdown(t *testing.T) {
+	quota := int64(4 << 20)
+	conf := testConf
+	conf.SetMemoryQuota(quota, QuotaBlock)
+	db := NewWithConfig(conf)
+
+	w := db.NewWriter()
+	n := 0
+	for ; db.MemoryInUse() < quota; n++ {
+		w.PutKV([]byte(fmt.Sprintf("%010d", n)), make([]byte, 100))
+	}
+
+	errch := make(chan error, 1)
+	go func() {
+		var err error
+		for i := n; err == nil; i++ {
+			_, err = w.TryPutKV([]byte(fmt.Sprintf("%010d", i)), make([]byte, 100))
+		}
+		errch <- err
+	}()
+
+	select {
+	case <-errch:
+		t.Fatalf("Expected writer to be blocked")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	db.Close()
+
+	select {
+	case err := <-errch:
+		if err != ErrShutdown {
+			t.Errorf("Expected ErrShutdown, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Expected writer to be unblocked by Close")
+	}
+}
+
+func TestTryPutDuplicate(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	key := []byte(fmt.Sprintf("%010d", 0))
+	if n, err := w.TryPutKV(key, []byte("v1")); n == nil || err != nil {
+		t.Errorf("Expected insert to succeed, got %v %v", n, err)
+	}
+
+	if n, err := w.TryPutKV(key, []byte("v2")); n != nil || err != nil {
+		t.Errorf("Expected duplicate insert to fail without error, got %v %v", n, err)
+	}
+
+	if n, err := w.TryPutKVWithTTL(key, []byte("v3"), time.Hour); n != nil || err != nil {
+		t.Errorf("Expected duplicate insert to fail without error, got %v %v", n, err)
+	}
+
+	snap, _ := w.NewSnapshot()
+	defer snap.Close()
+	if val, _ := snap.Get(key); string(val) != "v1" {
+		t.Errorf("Expected v1, got %s", val)
+	}
+}
+
+func TestProcessMemoryQuota(t *testing.T) {
+	SetProcessMemoryQuota(MemoryInUse()+(4<<20), QuotaReject)
+	defer SetProcessMemoryQuota(0, QuotaReject)
+
+	db1 := NewWithConfig(testConf)
+	defer db1.Close()
+	db2 := NewWithConfig(testConf)
+	defer db2.Close()
+
+	w1, w2 := db1.NewWriter(), db2.NewWriter()
+	var err error
+	for i := 0; i < 1000000 && err == nil; i++ {
+		if _, err = w1.TryPutKV([]byte(fmt.Sprintf("%010d", i)), make([]byte, 100)); err == nil {
+			_, err = w2.TryPutKV([]byte(fmt.Sprintf("%010d", i)), make([]byte, 100))
+		}
+	}
+
+	if err != ErrMemoryQuotaExceeded {
+		t.Errorf("Expected quota to be exceeded, got %v", err)
+	}
+
+	if db1.MemoryInUse() < 1<<20 || db2.MemoryInUse() < 1<<20 {
+		t.Errorf("Expected quota to be shared, got %d and %d", db1.MemoryInUse(), db2.MemoryInUse())
+	}
+}
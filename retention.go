@@ -0,0 +1,122 @@
+package memdb
+
+import (
+	"fmt"
+	"github.com/t3rm1n4l/memdb/skiplist"
+	"math"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+var ErrSnapshotNotRetained = fmt.Errorf("Snapshot sn is not retained")
+
+func (m *MemDB) hasRetention() bool {
+	return m.retainSns > 0 || m.retainPeriod > 0
+}
+
+func (m *MemDB) isRetained(s *Snapshot) bool {
+	if m.retainSns > 0 && m.getCurrSn()-s.sn <= m.retainSns {
+		return true
+	}
+
+	if m.retainPeriod > 0 && time.Since(time.Unix(int64(s.ts), 0)) < m.retainPeriod {
+		return true
+	}
+
+	return false
+}
+
+// Called under gcToken when the oldest closed snapshot s is retained. If
+// only the retention period holds it back, GC is scheduled for when it ages
+// out since no further snapshot may be created to trigger it.
+func (m *MemDB) scheduleRetentionGC(s *Snapshot) {
+	if m.retainPeriod == 0 || m.retainSns > 0 && m.getCurrSn()-s.sn <= m.retainSns {
+		return
+	}
+
+	d := time.Until(time.Unix(int64(s.ts), 0).Add(m.retainPeriod))
+	if d < time.Millisecond {
+		d = time.Millisecond
+	}
+
+	if m.retainTimer == nil {
+		m.retainTimer = time.AfterFunc(d, m.GC)
+	} else {
+		m.retainTimer.Reset(d)
+	}
+}
+
+// Lowest sn of the open snapshots. Items deleted after it cannot be
+// collected.
+func (m *MemDB) minLiveSn() uint64 {
+	buf := m.snapshots.MakeBuf()
+	defer m.snapshots.FreeBuf(buf)
+
+	iter := m.snapshots.NewIterator(CompareSnapshot, buf)
+	defer iter.Close()
+
+	iter.SeekFirst()
+	if iter.Valid() {
+		return (*Snapshot)(iter.Get()).sn
+	}
+
+	return math.MaxUint64
+}
+
+func (m *MemDB) findSnapshot(sn uint64) *Snapshot {
+	buf := m.snapshots.MakeBuf()
+	defer m.snapshots.FreeBuf(buf)
+
+	x := &Snapshot{sn: sn}
+	for _, l := range []*skiplist.Skiplist{m.snapshots, m.gcsnapshots} {
+		iter := l.NewIterator(CompareSnapshot, buf)
+		iter.Seek(unsafe.Pointer(x))
+		if iter.Valid() {
+			if s := (*Snapshot)(iter.Get()); CompareSnapshot(unsafe.Pointer(s), unsafe.Pointer(x)) == 0 {
+				iter.Close()
+				return s
+			}
+		}
+		iter.Close()
+	}
+
+	return nil
+}
+
+// OpenSnapshotAt returns a snapshot of the sequence number sn. The sn
+// should belong to an open snapshot or a closed snapshot which has not
+// been garbage collected yet as per the retention policy.
+func (m *MemDB) OpenSnapshotAt(sn uint64) (*Snapshot, error) {
+	select {
+	case <-m.shutdownCh:
+		return nil, ErrShutdown
+	default:
+	}
+
+	// Hold off snapshot GC until the snapshot is live. Close takes the
+	// token and never returns it.
+	select {
+	case <-m.gcToken:
+	case <-m.shutdownCh:
+		return nil, ErrShutdown
+	}
+	defer m.GC()
+	defer func() {
+		m.gcToken <- struct{}{}
+	}()
+
+	orig := m.findSnapshot(sn)
+	if sn <= m.lastGCSn || orig == nil {
+		return nil, ErrSnapshotNotRetained
+	}
+
+	buf := m.snapshots.MakeBuf()
+	defer m.snapshots.FreeBuf(buf)
+
+	snap := &Snapshot{db: m, sn: sn, ts: orig.ts, refCount: 1, count: orig.count, historical: true}
+	m.snapshots.Insert(unsafe.Pointer(snap), CompareSnapshot, buf, &m.snapshots.Stats)
+	atomic.AddInt64(&m.numSnaps, 1)
+
+	return snap, nil
+}
@@ -0,0 +1,201 @@
+package memdb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestOpenSnapshotAt(t *testing.T) {
+	conf := testConf
+	conf.SetSnapshotRetention(2, 0)
+	db := NewWithConfig(conf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 100; j++ {
+			w.Put([]byte(fmt.Sprintf("%d-%010d", i, j)))
+		}
+		snap, _ := w.NewSnapshot()
+		snap.Close()
+	}
+
+	if _, err := db.OpenSnapshotAt(1); err != ErrSnapshotNotRetained {
+		t.Errorf("Expected snapshot not retained, got %v", err)
+	}
+
+	if _, err := db.OpenSnapshotAt(6); err != ErrSnapshotNotRetained {
+		t.Errorf("Expected snapshot not retained, got %v", err)
+	}
+
+	snap4, err := db.OpenSnapshotAt(4)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if c := snap4.Count(); c != 400 {
+		t.Errorf("Expected count 400, got %d", c)
+	}
+
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 100; j++ {
+			w.Delete([]byte(fmt.Sprintf("%d-%010d", i, j)))
+		}
+		snap, _ := w.NewSnapshot()
+		snap.Close()
+	}
+
+	// Deleted items should remain visible in the reopened snapshot
+	VerifyCount(snap4, 400, t)
+	if _, ok := snap4.Get([]byte(fmt.Sprintf("%d-%010d", 3, 0))); !ok {
+		t.Errorf("Expected item to be found")
+	}
+
+	if _, ok := snap4.Get([]byte(fmt.Sprintf("%d-%010d", 4, 0))); ok {
+		t.Errorf("Expected item to be hidden")
+	}
+
+	snap4.Close()
+	for i := 0; i < 3; i++ {
+		snap, _ := w.NewSnapshot()
+		snap.Close()
+	}
+
+	for i := 0; i < 100; i++ {
+		if db.store.GetStats().NodeCount == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if c := db.store.GetStats().NodeCount; c != 0 {
+		t.Errorf("Expected all nodes to be collected, got %d", c)
+	}
+}
+
+func TestOpenSnapshotAtClose(t *testing.T) {
+	conf := testConf
+	conf.SetSnapshotRetention(2, 0)
+	db := NewWithConfig(conf)
+
+	w := db.NewWriter()
+	w.Put([]byte(fmt.Sprintf("%010d", 0)))
+	snap, _ := w.NewSnapshot()
+	snap.Close()
+
+	// Hold the token as a snapshot GC in progress would
+	<-db.gcToken
+
+	errch := make(chan error, 1)
+	go func() {
+		_, err := db.OpenSnapshotAt(snap.sn)
+		errch <- err
+	}()
+
+	select {
+	case <-errch:
+		t.Fatalf("Expected OpenSnapshotAt to wait for the token")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		db.Close()
+		close(closed)
+	}()
+
+	select {
+	case err := <-errch:
+		if err != ErrShutdown {
+			t.Errorf("Expected ErrShutdown, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Expected OpenSnapshotAt to be unblocked by Close")
+	}
+
+	db.gcToken <- struct{}{}
+	<-closed
+}
+
+func TestSnapshotRetentionPeriod(t *testing.T) {
+	conf := testConf
+	conf.SetSnapshotRetention(0, time.Hour)
+	db := NewWithConfig(conf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 100; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	snap1, _ := w.NewSnapshot()
+	sn := snap1.sn
+	snap1.Close()
+
+	for i := 0; i < 100; i++ {
+		w.Delete([]byte(fmt.Sprintf("%010d", i)))
+		snap, _ := w.NewSnapshot()
+		snap.Close()
+	}
+
+	snap2, err := db.OpenSnapshotAt(sn)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer snap2.Close()
+
+	VerifyCount(snap2, 100, t)
+
+	// Multiple snapshots can be opened at the same sn
+	snap3, err := db.OpenSnapshotAt(sn)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	snap3.Close()
+}
+
+// Snapshots retained for a period are collected once they age out, even
+// if no further snapshots are created
+func TestSnapshotRetentionExpiry(t *testing.T) {
+	conf := testConf
+	conf.SetSnapshotRetention(0, time.Second)
+	db := NewWithConfig(conf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 100; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	snap1, _ := w.NewSnapshot()
+	snap1.Close()
+
+	for i := 0; i < 100; i++ {
+		w.Delete([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap2, _ := w.NewSnapshot()
+	sn := snap2.sn
+	snap2.Close()
+
+	snap3, err := db.OpenSnapshotAt(sn)
+	if err != nil {
+		t.Fatalf("Expected snapshot to be retained, got %v", err)
+	}
+	snap3.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.WaitForGC(ctx, sn); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if c := db.store.GetStats().NodeCount; c != 0 {
+		t.Errorf("Expected all nodes to be collected, got %d", c)
+	}
+
+	if _, err := db.OpenSnapshotAt(sn); err != ErrSnapshotNotRetained {
+		t.Errorf("Expected snapshot not retained, got %v", err)
+	}
+}
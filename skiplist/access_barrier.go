@@ -118,7 +118,7 @@ func (ab *AccessBarrier) doCleanup() {
 			return
 		}
 
-		ab.freeSeqno++
+		atomic.AddUint64(&ab.freeSeqno, 1)
 		ab.callb(bs.objectRef)
 		ab.freeq.DeleteNode(node, CompareBS, buf2, &ab.freeq.Stats)
 	}
@@ -170,10 +170,19 @@ func (ab *AccessBarrier) FlushSession(ref unsafe.Pointer) {
 		atomic.CompareAndSwapPointer(&ab.session, bsPtr, newBsPtr)
 		bs := (*BarrierSession)(bsPtr)
 		bs.objectRef = ref
-		ab.activeSeqno++
-		bs.seqno = ab.activeSeqno
+		bs.seqno = atomic.AddUint64(&ab.activeSeqno, 1)
 
 		atomic.AddInt32(bs.liveCount, barrierFlushOffset+1)
 		ab.Release(bs)
 	}
 }
+
+// PendingSessions returns the number of flushed barrier sessions whose
+// destructor is yet to be called
+func (ab *AccessBarrier) PendingSessions() uint64 {
+	if ab.active {
+		return atomic.LoadUint64(&ab.activeSeqno) - atomic.LoadUint64(&ab.freeSeqno)
+	}
+
+	return 0
+}
@@ -31,6 +31,16 @@ func (it *Iterator) SeekFirst() {
 	it.valid = true
 }
 
+// SeekLast positions the iterator at the last item
+func (it *Iterator) SeekLast() {
+	it.valid = true
+	it.deleted = false
+	// A nil item compares greater than every item except the tail
+	it.s.findPath(nil, it.cmp, it.buf, &it.s.Stats)
+	it.prev = it.s.head
+	it.curr = it.buf.preds[0]
+}
+
 func (it *Iterator) SeekWithCmp(itm unsafe.Pointer, cmp CompareFn, eqCmp CompareFn) bool {
 	var found bool
 	if found = it.s.findPath(itm, cmp, it.buf, &it.s.Stats) != nil; found {
@@ -40,6 +50,9 @@ func (it *Iterator) SeekWithCmp(itm unsafe.Pointer, cmp CompareFn, eqCmp Compare
 		if found = eqCmp != nil && compare(eqCmp, itm, it.buf.preds[0].Item()) == 0; found {
 			it.prev = nil
 			it.curr = it.buf.preds[0]
+		} else if found = eqCmp != nil && compare(eqCmp, itm, it.buf.succs[0].Item()) == 0; found {
+			it.prev = it.buf.preds[0]
+			it.curr = it.buf.succs[0]
 		}
 	}
 	return found
@@ -53,8 +66,24 @@ func (it *Iterator) Seek(itm unsafe.Pointer) bool {
 	return found
 }
 
+// SeekForPrev positions the iterator at the item equal to itm or at the
+// last item which is less than itm
+func (it *Iterator) SeekForPrev(itm unsafe.Pointer) bool {
+	it.valid = true
+	it.deleted = false
+	found := it.s.findPath(itm, it.cmp, it.buf, &it.s.Stats) != nil
+	if found {
+		it.prev = it.buf.preds[0]
+		it.curr = it.buf.succs[0]
+	} else {
+		it.prev = it.s.head
+		it.curr = it.buf.preds[0]
+	}
+	return found
+}
+
 func (it *Iterator) Valid() bool {
-	if it.valid && it.curr == it.s.tail {
+	if it.valid && (it.curr == it.s.tail || it.curr == it.s.head) {
 		it.valid = false
 	}
 
@@ -108,6 +137,22 @@ retry:
 	}
 }
 
+// Nodes do not have back links. Predecessor is located by searching the
+// path to the current item. Head is used as the prev hint and it only
+// makes the delete helper in Next take the slow path.
+func (it *Iterator) Prev() {
+	if it.curr == it.s.head {
+		it.valid = false
+		return
+	}
+
+	it.valid = true
+	it.deleted = false
+	it.s.findPath(it.curr.Item(), it.cmp, it.buf, &it.s.Stats)
+	it.prev = it.s.head
+	it.curr = it.buf.preds[0]
+}
+
 func (it *Iterator) Close() {
 	it.s.barrier.Release(it.bs)
 }
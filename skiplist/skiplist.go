@@ -3,6 +3,7 @@ package skiplist
 import (
 	"math/rand"
 	"runtime"
+	"sync"
 	"sync/atomic"
 	"unsafe"
 )
@@ -52,6 +53,9 @@ type Skiplist struct {
 	newNode  func(itm unsafe.Pointer, level int) *Node
 	freeNode func(*Node)
 
+	// Free list of action buffers
+	bufPool sync.Pool
+
 	Config
 }
 
@@ -93,6 +97,12 @@ func NewWithConfig(cfg Config) *Skiplist {
 
 	s.head = head
 	s.tail = tail
+	s.bufPool.New = func() interface{} {
+		return &ActionBuffer{
+			preds: make([]*Node, MaxLevel+1),
+			succs: make([]*Node, MaxLevel+1),
+		}
+	}
 
 	return s
 }
@@ -112,13 +122,12 @@ type ActionBuffer struct {
 }
 
 func (s *Skiplist) MakeBuf() *ActionBuffer {
-	return &ActionBuffer{
-		preds: make([]*Node, MaxLevel+1),
-		succs: make([]*Node, MaxLevel+1),
-	}
+	return s.bufPool.Get().(*ActionBuffer)
 }
 
+// Buffer should not be used after it is freed
 func (s *Skiplist) FreeBuf(b *ActionBuffer) {
+	s.bufPool.Put(b)
 }
 
 func (s *Skiplist) Size(n *Node) int {
@@ -210,6 +219,8 @@ func (s *Skiplist) Insert2(itm unsafe.Pointer, inscmp CompareFn, eqCmp CompareFn
 	return s.Insert3(itm, inscmp, eqCmp, buf, itemLevel, false, sts)
 }
 
+// If eqCmp is non-nil, insert fails when either neighbour of the insert
+// position compares equal to itm as per eqCmp
 func (s *Skiplist) Insert3(itm unsafe.Pointer, insCmp CompareFn, eqCmp CompareFn,
 	buf *ActionBuffer, itemLevel int, skipFindPath bool, sts *Stats) (*Node, bool) {
 
@@ -223,7 +234,8 @@ retry:
 		skipFindPath = false
 	} else {
 		if s.findPath(itm, insCmp, buf, sts) != nil ||
-			eqCmp != nil && compare(eqCmp, itm, buf.preds[0].Item()) == 0 {
+			eqCmp != nil && (compare(eqCmp, itm, buf.preds[0].Item()) == 0 ||
+				compare(eqCmp, itm, buf.succs[0].Item()) == 0) {
 
 			s.freeNode(x)
 			return nil, false
@@ -319,6 +331,26 @@ func (s *Skiplist) deleteNode(n *Node, cmp CompareFn, buf *ActionBuffer, sts *St
 	return false
 }
 
+// Lookup locates the first node which is equal to itm as per cmp and invokes
+// callb for the node and the subsequent nodes comparing equal to itm until
+// callb returns false. Nodes marked as deleted are also passed to callb.
+func (s *Skiplist) Lookup(itm unsafe.Pointer, cmp CompareFn,
+	buf *ActionBuffer, sts *Stats, callb func(*Node) bool) {
+	token := s.barrier.Acquire()
+	defer s.barrier.Release(token)
+
+	if s.findPath(itm, cmp, buf, sts) == nil {
+		return
+	}
+
+	for n := buf.succs[0]; n != s.tail && compare(cmp, n.Item(), itm) == 0; {
+		if !callb(n) {
+			return
+		}
+		n, _ = n.getNext(0)
+	}
+}
+
 // Explicit barrier and release should be used by the caller before
 // and after this function call
 func (s *Skiplist) GetRangeSplitItems(nways int) []unsafe.Pointer {
@@ -352,3 +384,42 @@ repeat:
 
 	return itms
 }
+
+// Level returns the highest level in use
+func (s *Skiplist) Level() int {
+	return int(atomic.LoadInt32(&s.level))
+}
+
+// LevelProbability returns the probability of a node at a level to be
+// also linked at the next level
+func (s *Skiplist) LevelProbability() float64 {
+	return p
+}
+
+// VisitLevel calls callb for the items of the nodes linked at the given
+// level, starting from the first node whose item is not less than itm.
+// A nil itm starts from the first node. The nodes at a level are a random
+// sample of all the nodes with probability p^level. Visiting stops when
+// callb returns false.
+func (s *Skiplist) VisitLevel(itm unsafe.Pointer, cmp CompareFn, level int,
+	buf *ActionBuffer, callb func(unsafe.Pointer) bool) {
+	bs := s.barrier.Acquire()
+	defer s.barrier.Release(bs)
+
+	node := s.head
+	if itm != nil && level <= s.Level() {
+		s.findPath(itm, cmp, buf, &s.Stats)
+		node = buf.preds[level]
+	}
+
+	for {
+		node, _ = node.getNext(level)
+		if node == s.tail {
+			return
+		}
+
+		if _, deleted := node.getNext(level); !deleted && !callb(node.Item()) {
+			return
+		}
+	}
+}
@@ -40,6 +40,89 @@ func TestInsert(t *testing.T) {
 	}
 }
 
+func TestReverseIterator(t *testing.T) {
+	s := New()
+	cmp := CompareBytes
+	buf := s.MakeBuf()
+	defer s.FreeBuf(buf)
+
+	for i := 0; i < 2000; i++ {
+		s.Insert(NewByteKeyItem([]byte(fmt.Sprintf("%010d", i))), cmp, buf, &s.Stats)
+	}
+
+	for i := 1000; i < 2000; i += 2 {
+		s.Delete(NewByteKeyItem([]byte(fmt.Sprintf("%010d", i))), cmp, buf, &s.Stats)
+	}
+
+	itr := s.NewIterator(cmp, buf)
+	defer itr.Close()
+
+	count := 0
+	for itr.SeekLast(); itr.Valid(); itr.Prev() {
+		count++
+	}
+
+	if count != 1500 {
+		t.Errorf("Expected count = 1500, got %v", count)
+	}
+
+	itr.SeekForPrev(NewByteKeyItem([]byte(fmt.Sprintf("%010d", 1500))))
+	if got := string(*(*byteKeyItem)(itr.Get())); got != fmt.Sprintf("%010d", 1499) {
+		t.Errorf("Expected %010d, got %s", 1499, got)
+	}
+
+	itr.SeekForPrev(NewByteKeyItem([]byte(fmt.Sprintf("%010d", 999))))
+	for i := 999; i > 990; i-- {
+		if got := string(*(*byteKeyItem)(itr.Get())); got != fmt.Sprintf("%010d", i) {
+			t.Errorf("Expected %010d, got %s", i, got)
+		}
+		itr.Prev()
+	}
+
+	itr.SeekFirst()
+	itr.Prev()
+	if itr.Valid() {
+		t.Errorf("Expected invalid iterator")
+	}
+}
+
+func TestReverseIteratorConcurrentDelete(t *testing.T) {
+	var wg sync.WaitGroup
+	s := New()
+	cmp := CompareInt
+	buf := s.MakeBuf()
+	defer s.FreeBuf(buf)
+
+	n := 100000
+	for i := 0; i < n; i++ {
+		itm := intKeyItem(i)
+		s.Insert(unsafe.Pointer(&itm), cmp, buf, &s.Stats)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		buf := s.MakeBuf()
+		defer s.FreeBuf(buf)
+		for i := 0; i < n; i += 3 {
+			itm := intKeyItem(i)
+			s.Delete(unsafe.Pointer(&itm), cmp, buf, &s.Stats)
+		}
+	}()
+
+	itr := s.NewIterator(cmp, buf)
+	last := n
+	for itr.SeekLast(); itr.Valid(); itr.Prev() {
+		v := int(*(*intKeyItem)(itr.Get()))
+		if v >= last {
+			t.Errorf("Expected descending order, got %d after %d", v, last)
+		}
+		last = v
+	}
+	itr.Close()
+	wg.Wait()
+}
+
 func doInsert(sl *Skiplist, wg *sync.WaitGroup, n int, isRand bool) {
 	defer wg.Done()
 	buf := sl.MakeBuf()
@@ -76,6 +159,48 @@ func doGet(sl *Skiplist, wg *sync.WaitGroup, n int) {
 
 }
 
+// Items comparing equal as per eqCmp on either side of the insert position
+// fail the insert and are found by SeekWithCmp
+func TestInsertEqualNeighbour(t *testing.T) {
+	s := New()
+	buf := s.MakeBuf()
+	defer s.FreeBuf(buf)
+
+	prefixCmp := func(this, that unsafe.Pointer) int {
+		return int((*(*byteKeyItem)(this))[0]) - int((*(*byteKeyItem)(that))[0])
+	}
+
+	for _, k := range []string{"a-3", "b-1"} {
+		s.Insert(NewByteKeyItem([]byte(k)), CompareBytes, buf, &s.Stats)
+	}
+
+	for _, k := range []string{"a-2", "a-4", "b-0", "b-2"} {
+		if _, ok := s.Insert2(NewByteKeyItem([]byte(k)), CompareBytes, prefixCmp,
+			buf, rand.Float32, &s.Stats); ok {
+			t.Errorf("Expected insert of %s to fail", k)
+		}
+	}
+
+	if _, ok := s.Insert2(NewByteKeyItem([]byte("c-1")), CompareBytes, prefixCmp,
+		buf, rand.Float32, &s.Stats); !ok {
+		t.Errorf("Expected insert of c-1 to succeed")
+	}
+
+	itr := s.NewIterator(CompareBytes, buf)
+	defer itr.Close()
+	for k, exp := range map[string]string{"a-0": "a-3", "a-9": "a-3", "b-0": "b-1", "c-0": "c-1"} {
+		if !itr.SeekWithCmp(NewByteKeyItem([]byte(k)), CompareBytes, prefixCmp) {
+			t.Errorf("Expected %s to be found", k)
+		} else if got := string(*(*byteKeyItem)(itr.Get())); got != exp {
+			t.Errorf("Expected %s for %s, got %s", exp, k, got)
+		}
+	}
+
+	if itr.SeekWithCmp(NewByteKeyItem([]byte("d-0")), CompareBytes, prefixCmp) {
+		t.Errorf("Expected d-0 not to be found")
+	}
+}
+
 func TestInsertPerf(t *testing.T) {
 	var wg sync.WaitGroup
 	sl := New()
@@ -139,6 +264,38 @@ func TestGetRangeSplitItems(t *testing.T) {
 	fmt.Println("No of items in each range", diff)
 }
 
+func TestVisitLevel(t *testing.T) {
+	s := New()
+	cmp := CompareBytes
+	buf := s.MakeBuf()
+	defer s.FreeBuf(buf)
+
+	for i := 0; i < 2000; i++ {
+		s.Insert(NewByteKeyItem([]byte(fmt.Sprintf("%010d", i))), cmp, buf, &s.Stats)
+	}
+
+	start := NewByteKeyItem([]byte(fmt.Sprintf("%010d", 1500)))
+	for l := 0; l <= s.Level(); l++ {
+		count := 0
+		prev := fmt.Sprintf("%010d", 1499)
+		s.VisitLevel(start, cmp, l, buf, func(itm unsafe.Pointer) bool {
+			got := string(*(*byteKeyItem)(itm))
+			if got <= prev {
+				t.Errorf("Expected item after %s, got %s", prev, got)
+			}
+			prev = got
+			count++
+			return true
+		})
+
+		if l == 0 && count != 500 {
+			t.Errorf("Expected count = 500, got %v", count)
+		} else if count > 500 {
+			t.Errorf("Expected a sample of 500 items at level %d, got %v", l, count)
+		}
+	}
+}
+
 func TestBuilder(t *testing.T) {
 	var wg sync.WaitGroup
 
@@ -4,16 +4,16 @@ import "fmt"
 import "sync/atomic"
 
 type StatsReport struct {
-	ReadConflicts       uint64
-	InsertConflicts     uint64
-	NextPointersPerNode float64
-	NodeDistribution    [MaxLevel + 1]int64
-	NodeCount           int
-	SoftDeletes         int64
-	Memory              int64
-
-	NodeAllocs int64
-	NodeFrees  int64
+	ReadConflicts       uint64              `json:"read_conflicts"`
+	InsertConflicts     uint64              `json:"insert_conflicts"`
+	NextPointersPerNode float64             `json:"next_pointers_per_node"`
+	NodeDistribution    [MaxLevel + 1]int64 `json:"node_distribution"`
+	NodeCount           int                 `json:"node_count"`
+	SoftDeletes         int64               `json:"soft_deletes"`
+	Memory              int64               `json:"memory_used"`
+
+	NodeAllocs int64 `json:"node_allocs"`
+	NodeFrees  int64 `json:"node_frees"`
 }
 
 func (report *StatsReport) Apply(s *Stats) {
@@ -32,7 +32,9 @@ func (report *StatsReport) Apply(s *Stats) {
 
 	report.SoftDeletes += s.softDeletes
 	report.NodeCount = totalNodes
-	report.NextPointersPerNode = float64(totalNextPtrs) / float64(totalNodes)
+	if totalNodes > 0 {
+		report.NextPointersPerNode = float64(totalNextPtrs) / float64(totalNodes)
+	}
 	report.NodeAllocs += s.nodeAllocs
 	report.NodeFrees += s.nodeFrees
 	report.Memory += s.usedBytes
@@ -69,6 +71,14 @@ func (s *Stats) AddUint64(src *uint64, val uint64) {
 	}
 }
 
+func (s *Stats) MemoryInUse() int64 {
+	if s.isLocal {
+		return s.usedBytes
+	}
+
+	return atomic.LoadInt64(&s.usedBytes)
+}
+
 func (s *Stats) Merge(sts *Stats) {
 	atomic.AddUint64(&s.insertConflicts, sts.insertConflicts)
 	sts.insertConflicts = 0
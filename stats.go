@@ -0,0 +1,244 @@
+package memdb
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/t3rm1n4l/memdb/mm"
+	"github.com/t3rm1n4l/memdb/skiplist"
+	"io"
+	"sync/atomic"
+)
+
+// WriterStats holds the state of a writer which is yet to be picked up by
+// the next snapshot
+type WriterStats struct {
+	ID               int   `json:"id"`
+	PendingItems     int64 `json:"pending_items"`
+	GCListLength     int64 `json:"gclist_length"`
+	PendingMutations int   `json:"pending_mutations"`
+	OverQuota        bool  `json:"over_quota"`
+}
+
+// DBStats is a point in time view of the DB statistics
+type DBStats struct {
+	ID          int    `json:"id"`
+	ItemsCount  int64  `json:"items_count"`
+	MemoryInUse int64  `json:"memory_in_use"`
+	CurrSn      uint64 `json:"curr_sn"`
+	LastGCSn    uint64 `json:"last_gc_sn"`
+
+	// Snapshots which are open and closed snapshots waiting to be collected
+	OpenSnapshots      int64 `json:"open_snapshots"`
+	PendingGCSnapshots int64 `json:"pending_gc_snapshots"`
+
+	// Dead items in the gclists of open and pending snapshots
+	SnapshotGCListLength int64 `json:"snapshot_gclist_length"`
+	PendingGCListLength  int64 `json:"pending_gclist_length"`
+
+	Store   skiplist.StatsReport `json:"store"`
+	Writers []WriterStats        `json:"writers"`
+	GC      GCStats              `json:"gc"`
+
+	DeltaRestored      uint64 `json:"delta_restored"`
+	DeltaRestoreFailed uint64 `json:"delta_restore_failed"`
+
+	// Available only if memory management is enabled
+	Allocator *mm.AllocStats `json:"allocator,omitempty"`
+}
+
+func (m *MemDB) Stats() DBStats {
+	s := DBStats{
+		ID:                 m.id,
+		ItemsCount:         m.ItemsCount(),
+		CurrSn:             m.getCurrSn(),
+		LastGCSn:           atomic.LoadUint64(&m.lastGCSn),
+		OpenSnapshots:      atomic.LoadInt64(&m.numSnaps),
+		DeltaRestored:      atomic.LoadUint64(&m.restoreStats.DeltaRestored),
+		DeltaRestoreFailed: atomic.LoadUint64(&m.restoreStats.DeltaRestoreFailed),
+	}
+
+	s.Store = m.aggrStoreStats()
+	s.MemoryInUse = s.Store.Memory + m.snapshots.MemoryInUse() + m.gcsnapshots.MemoryInUse()
+
+	m.wlock.Lock()
+	for w := m.wlist; w != nil; w = w.next {
+		w.mu.Lock()
+		s.Writers = append(s.Writers, WriterStats{
+			ID:               w.id,
+			PendingItems:     w.count,
+			GCListLength:     w.gclen,
+			PendingMutations: len(w.mutations),
+			OverQuota:        atomic.LoadInt32(&w.overQuota) == 1,
+		})
+		w.mu.Unlock()
+	}
+	m.wlock.Unlock()
+
+	m.visitSnapshots(m.snapshots, func(snap *Snapshot) {
+		s.SnapshotGCListLength += snap.gclen
+	})
+
+	m.visitSnapshots(m.gcsnapshots, func(snap *Snapshot) {
+		s.PendingGCSnapshots++
+		s.PendingGCListLength += snap.gclen
+	})
+
+	s.GC = m.GCStats()
+	if m.useMemoryMgmt {
+		sts := mm.AllocatorStats()
+		s.Allocator = &sts
+	}
+
+	return s
+}
+
+func (m *MemDB) visitSnapshots(l *skiplist.Skiplist, callb func(*Snapshot)) {
+	buf := l.MakeBuf()
+	defer l.FreeBuf(buf)
+	iter := l.NewIterator(CompareSnapshot, buf)
+	defer iter.Close()
+
+	for iter.SeekFirst(); iter.Valid(); iter.Next() {
+		callb((*Snapshot)(iter.Get()))
+	}
+}
+
+type promSample struct {
+	labels string
+	value  interface{}
+}
+
+type promMetric struct {
+	name    string
+	typ     string
+	help    string
+	samples []promSample
+}
+
+func (s DBStats) promMetrics() []*promMetric {
+	db := fmt.Sprintf("db=\"%d\"", s.ID)
+	gauge := func(name, help string, v interface{}) *promMetric {
+		return &promMetric{name: name, typ: "gauge", help: help,
+			samples: []promSample{{db, v}}}
+	}
+
+	counter := func(name, help string, v interface{}) *promMetric {
+		m := gauge(name, help, v)
+		m.typ = "counter"
+		return m
+	}
+
+	metrics := []*promMetric{
+		gauge("memdb_items_count", "Number of live items", s.ItemsCount),
+		gauge("memdb_memory_in_use_bytes", "Memory used by the store and snapshots", s.MemoryInUse),
+		gauge("memdb_curr_sn", "Current sequence number", s.CurrSn),
+		gauge("memdb_last_gc_sn", "Sequence number of the last collected snapshot", s.LastGCSn),
+		gauge("memdb_open_snapshots", "Number of open snapshots", s.OpenSnapshots),
+		gauge("memdb_pending_gc_snapshots", "Number of closed snapshots waiting to be collected", s.PendingGCSnapshots),
+		gauge("memdb_snapshot_gclist_length", "Dead items held by open snapshots", s.SnapshotGCListLength),
+		gauge("memdb_pending_gclist_length", "Dead items held by snapshots waiting to be collected", s.PendingGCListLength),
+		counter("memdb_read_conflicts_total", "Skiplist read conflicts", s.Store.ReadConflicts),
+		counter("memdb_insert_conflicts_total", "Skiplist insert conflicts", s.Store.InsertConflicts),
+		gauge("memdb_soft_deletes", "Skiplist nodes marked deleted", s.Store.SoftDeletes),
+		gauge("memdb_next_pointers_per_node", "Average next pointers per skiplist node", s.Store.NextPointersPerNode),
+		gauge("memdb_gc_oldest_live_sn", "Sn of the oldest open snapshot", s.GC.OldestLiveSn),
+		gauge("memdb_gc_blocked_snapshots", "Closed snapshots blocked behind an open snapshot", s.GC.BlockedSnapshots),
+		gauge("memdb_gc_queued_lists", "gclists queued for collection workers", s.GC.QueuedLists),
+		gauge("memdb_gc_queue_capacity", "Capacity of the collection queue", s.GC.QueueCap),
+		gauge("memdb_gc_unlinking_lists", "gclists being unlinked from the store", s.GC.UnlinkingLists),
+		gauge("memdb_gc_barrier_wait_lists", "Unlinked gclists waiting for their barrier session", s.GC.BarrierWaitLists),
+		gauge("memdb_gc_pending_barrier_sessions", "Barrier sessions waiting to be terminated", s.GC.PendingSessions),
+		gauge("memdb_gc_free_queued_lists", "gclists queued for free workers", s.GC.FreeQueuedLists),
+		gauge("memdb_gc_freeing_lists", "gclists being freed", s.GC.FreeingLists),
+		gauge("memdb_gc_unlinked_upto_sn", "Items dead at or before this sn are unlinked", s.GC.UnlinkedUptoSn),
+		gauge("memdb_gc_freed_upto_sn", "Items dead at or before this sn are freed", s.GC.FreedUptoSn),
+		counter("memdb_delta_restored_total", "Items restored from delta files", s.DeltaRestored),
+		counter("memdb_delta_restore_failed_total", "Items failed to restore from delta files", s.DeltaRestoreFailed),
+	}
+
+	levels := &promMetric{name: "memdb_level_nodes", typ: "gauge", help: "Skiplist nodes per level"}
+	for i, c := range s.Store.NodeDistribution {
+		if c > 0 {
+			levels.samples = append(levels.samples, promSample{fmt.Sprintf("%s,level=\"%d\"", db, i), c})
+		}
+	}
+	metrics = append(metrics, levels)
+
+	pending := &promMetric{name: "memdb_writer_pending_items", typ: "gauge", help: "Items count delta not yet published by a writer"}
+	gclen := &promMetric{name: "memdb_writer_gclist_length", typ: "gauge", help: "Dead items not yet handed over to a snapshot"}
+	muts := &promMetric{name: "memdb_writer_pending_mutations", typ: "gauge", help: "Mutations not yet published to subscribers"}
+	for _, w := range s.Writers {
+		labels := fmt.Sprintf("%s,writer=\"%d\"", db, w.ID)
+		pending.samples = append(pending.samples, promSample{labels, w.PendingItems})
+		gclen.samples = append(gclen.samples, promSample{labels, w.GCListLength})
+		muts.samples = append(muts.samples, promSample{labels, w.PendingMutations})
+	}
+	metrics = append(metrics, pending, gclen, muts)
+
+	return metrics
+}
+
+// The allocator is shared by all the DB instances, hence its metrics carry
+// no db label
+func allocPromMetrics(a *mm.AllocStats) []*promMetric {
+	metric := func(name, typ, help string, v interface{}) *promMetric {
+		return &promMetric{name: name, typ: typ, help: help,
+			samples: []promSample{{"", v}}}
+	}
+
+	return []*promMetric{
+		metric("memdb_allocator_mallocs_total", "counter", "Allocations made by the allocator", a.Mallocs),
+		metric("memdb_allocator_frees_total", "counter", "Frees made by the allocator", a.Frees),
+		metric("memdb_allocator_resident_bytes", "gauge", "Memory held by the allocator", a.Resident),
+	}
+}
+
+// WritePrometheus writes the stats in the Prometheus text exposition format
+func (s DBStats) WritePrometheus(w io.Writer) error {
+	return WritePrometheus(w, s)
+}
+
+// WritePrometheus writes the stats of multiple DBs in the Prometheus text
+// exposition format. Samples of a metric are grouped together.
+func WritePrometheus(w io.Writer, stats ...DBStats) error {
+	var metrics []*promMetric
+	var alloc *mm.AllocStats
+	index := make(map[string]*promMetric)
+	for _, s := range stats {
+		for _, pm := range s.promMetrics() {
+			if m, ok := index[pm.name]; ok {
+				m.samples = append(m.samples, pm.samples...)
+			} else {
+				index[pm.name] = pm
+				metrics = append(metrics, pm)
+			}
+		}
+
+		if s.Allocator != nil {
+			alloc = s.Allocator
+		}
+	}
+
+	if alloc != nil {
+		metrics = append(metrics, allocPromMetrics(alloc)...)
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, pm := range metrics {
+		if len(pm.samples) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(bw, "# HELP %s %s\n", pm.name, pm.help)
+		fmt.Fprintf(bw, "# TYPE %s %s\n", pm.name, pm.typ)
+		for _, smp := range pm.samples {
+			if smp.labels == "" {
+				fmt.Fprintf(bw, "%s %v\n", pm.name, smp.value)
+			} else {
+				fmt.Fprintf(bw, "%s{%s} %v\n", pm.name, smp.labels, smp.value)
+			}
+		}
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,139 @@
+package memdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	n := 1000
+	w := db.NewWriter()
+	for i := 0; i < n; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	snap1, _ := w.NewSnapshot()
+	defer snap1.Close()
+
+	for i := 0; i < n/2; i++ {
+		w.Delete([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	sts := db.Stats()
+	if sts.ItemsCount != int64(n) {
+		t.Errorf("Expected items count %d, got %d", n, sts.ItemsCount)
+	}
+
+	if sts.OpenSnapshots != 1 {
+		t.Errorf("Expected 1 open snapshot, got %d", sts.OpenSnapshots)
+	}
+
+	var pending, gclen int64
+	for _, ws := range sts.Writers {
+		pending += ws.PendingItems
+		gclen += ws.GCListLength
+	}
+
+	if pending != -int64(n/2) || gclen != int64(n/2) {
+		t.Errorf("Unexpected writer stats %+v", sts.Writers)
+	}
+
+	snap2, _ := w.NewSnapshot()
+	defer snap2.Close()
+
+	sts = db.Stats()
+	if sts.SnapshotGCListLength != int64(n/2) {
+		t.Errorf("Expected gclist of %d items in snapshots, got %d", n/2, sts.SnapshotGCListLength)
+	}
+
+	if sts.Store.NodeCount != n || sts.MemoryInUse <= 0 {
+		t.Errorf("Unexpected store stats %+v", sts.Store)
+	}
+
+	bs, err := json.Marshal(sts)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var fields map[string]interface{}
+	json.Unmarshal(bs, &fields)
+	for _, f := range []string{"items_count", "memory_in_use", "open_snapshots", "pending_gc_snapshots", "store", "writers"} {
+		if _, ok := fields[f]; !ok {
+			t.Errorf("Expected field %s in %s", f, bs)
+		}
+	}
+}
+
+func TestStatsPrometheus(t *testing.T) {
+	db1 := NewWithConfig(testConf)
+	defer db1.Close()
+	db2 := NewWithConfig(testConf)
+	defer db2.Close()
+
+	for _, db := range []*MemDB{db1, db2} {
+		w := db.NewWriter()
+		for i := 0; i < 100; i++ {
+			w.Put([]byte(fmt.Sprintf("%010d", i)))
+		}
+		snap, _ := w.NewSnapshot()
+		snap.Close()
+	}
+
+	var buf bytes.Buffer
+	if err := WritePrometheus(&buf, db1.Stats(), db2.Stats()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	out := buf.String()
+	for _, l := range []string{
+		"# TYPE memdb_items_count gauge",
+		fmt.Sprintf("memdb_items_count{db=\"%d\"} 100", db1.id),
+		fmt.Sprintf("memdb_items_count{db=\"%d\"} 100", db2.id),
+		"# TYPE memdb_read_conflicts_total counter",
+		fmt.Sprintf("memdb_writer_pending_items{db=\"%d\",writer=\"0\"} 0", db1.id),
+	} {
+		if !strings.Contains(out, l+"\n") {
+			t.Errorf("Expected line %q in output", l)
+		}
+	}
+
+	if c := strings.Count(out, "# TYPE memdb_items_count "); c != 1 {
+		t.Errorf("Expected metric type to be declared once, got %d", c)
+	}
+
+	// The allocator is shared by the DBs
+	if c := strings.Count(out, "\nmemdb_allocator_mallocs_total "); c != 1 {
+		t.Errorf("Expected one unlabelled allocator sample, got %d", c)
+	}
+}
+
+func TestStatsWriterID(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	w0 := db.NewWriter()
+	w1 := db.NewWriter()
+	w2 := db.NewWriter()
+	w1.Close()
+	w0.Put([]byte("k1"))
+	w2.Put([]byte("k2"))
+	w2.Put([]byte("k3"))
+
+	// Ids do not shift when other writers are closed
+	sts := db.Stats()
+	if len(sts.Writers) != 2 {
+		t.Errorf("Expected 2 writers, got %d", len(sts.Writers))
+	}
+
+	for _, ws := range sts.Writers {
+		if ws.ID == 1 || ws.ID == 0 && ws.PendingItems != 1 || ws.ID == 2 && ws.PendingItems != 2 {
+			t.Errorf("Unexpected writer stats %+v", ws)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package memdb
+
+import (
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/t3rm1n4l/memdb/skiplist"
+)
+
+func unixNow() uint32 {
+	return uint32(time.Now().Unix())
+}
+
+// Expiry time is rounded up to the next second
+func expiryTime(ttl time.Duration) uint32 {
+	t := time.Now().Add(ttl)
+	if t.Nanosecond() > 0 {
+		t = t.Add(time.Second)
+	}
+
+	return uint32(t.Unix())
+}
+
+// PutWithTTL inserts an item which expires after ttl
+func (w *Writer) PutWithTTL(bs []byte, ttl time.Duration) {
+	w.PutKVWithTTL(bs, nil, ttl)
+}
+
+func (w *Writer) PutKVWithTTL(key, val []byte, ttl time.Duration) {
+	w.TryPutKVWithTTL(key, val, ttl)
+}
+
+// TryPutWithTTL is PutWithTTL which fails with ErrMemoryQuotaExceeded if
+// the write is rejected by the memory quota. A nil node without an error
+// is returned if the key already exists.
+func (w *Writer) TryPutWithTTL(bs []byte, ttl time.Duration) (*skiplist.Node, error) {
+	return w.TryPutKVWithTTL(bs, nil, ttl)
+}
+
+func (w *Writer) TryPutKVWithTTL(key, val []byte, ttl time.Duration) (*skiplist.Node, error) {
+	if err := w.checkQuota(); err != nil {
+		return nil, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	x := w.newItem(key, val, w.useMemoryMgmt)
+	x.expiry = expiryTime(ttl)
+	return w.insert(x, w.getCurrSn()), nil
+}
+
+// Number of items checked for expiry per tick. The scan resumes from the
+// key at which the previous tick stopped, hence a pass over a large store
+// is spread over several ticks.
+const expiryScanBatch = 1 << 16
+
+// Expired items are converted into regular deletes using a dedicated
+// writer. Snapshot GC reclaims them once older snapshots are closed.
+func (m *MemDB) expiryWorker(w *Writer) {
+	defer m.expiryWg.Done()
+
+	ticker := time.NewTicker(m.expiryInterval)
+	defer ticker.Stop()
+
+	var cursor *Item
+	for {
+		select {
+		case <-m.expiryStop:
+			return
+		case <-ticker.C:
+			cursor = m.deleteExpired(w, cursor)
+		}
+	}
+}
+
+// Deletes the expired items among the next expiryScanBatch items from
+// cursor. Returns the item to resume from, or nil once the scan reaches the
+// end of the store.
+func (m *MemDB) deleteExpired(w *Writer, cursor *Item) *Item {
+	buf := m.store.MakeBuf()
+	defer m.store.FreeBuf(buf)
+
+	iter := m.store.NewIterator(m.iterCmp, buf)
+	defer func() {
+		iter.Close()
+	}()
+
+	if cursor == nil {
+		iter.SeekFirst()
+	} else {
+		iter.Seek(unsafe.Pointer(cursor))
+	}
+
+	now := unixNow()
+	for n := 1; iter.Valid(); n++ {
+		itm := (*Item)(iter.Get())
+		if itm.hasExpired(now) && atomic.LoadUint64(&itm.deadSn) == 0 {
+			w.DeleteNode(iter.GetNode())
+		}
+
+		iter.Next()
+		if !iter.Valid() {
+			break
+		}
+
+		switch {
+		case n == expiryScanBatch:
+			return m.ptrToItem(iter.Get())
+		case m.refreshRate > 0 && n%m.refreshRate == 0:
+			// Let the items freed in the meantime be reclaimed
+			itm := m.ptrToItem(iter.Get())
+			iter.Close()
+			iter = m.store.NewIterator(m.iterCmp, buf)
+			iter.Seek(unsafe.Pointer(itm))
+		}
+	}
+
+	return nil
+}
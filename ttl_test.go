@@ -0,0 +1,134 @@
+package memdb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTTL(t *testing.T) {
+	conf := testConf
+	conf.SetExpiryInterval(100 * time.Millisecond)
+	db := NewWithConfig(conf)
+	defer db.Close()
+
+	n := 1000
+	w := db.NewWriter()
+	for i := 0; i < n; i++ {
+		w.PutKVWithTTL([]byte(fmt.Sprintf("%010d", i)), []byte("v1"), time.Second)
+		w.Put([]byte(fmt.Sprintf("%010d", i+n)))
+	}
+
+	snap1, _ := w.NewSnapshot()
+	defer snap1.Close()
+	VerifyCount(snap1, 2*n, t)
+
+	time.Sleep(2500 * time.Millisecond)
+
+	snap2, _ := w.NewSnapshot()
+	VerifyCount(snap2, n, t)
+	if _, ok := snap2.Get([]byte(fmt.Sprintf("%010d", 0))); ok {
+		t.Errorf("Expected expired item to be hidden")
+	}
+	snap2.Close()
+
+	// Expired items remain visible in the older snapshot
+	VerifyCount(snap1, 2*n, t)
+	if v, _ := snap1.Get([]byte(fmt.Sprintf("%010d", 0))); string(v) != "v1" {
+		t.Errorf("Expected v1, got %s", v)
+	}
+
+	w.PutKV([]byte(fmt.Sprintf("%010d", 0)), []byte("v2"))
+
+	snap3, _ := w.NewSnapshot()
+	defer snap3.Close()
+
+	if c := snap3.Count(); c != int64(n+1) {
+		t.Errorf("Expected count %d, got %d", n+1, c)
+	}
+
+	if v, _ := snap3.Get([]byte(fmt.Sprintf("%010d", 0))); string(v) != "v2" {
+		t.Errorf("Expected v2, got %s", v)
+	}
+}
+
+func TestTTLScanBatch(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	n := expiryScanBatch + 1000
+	w := db.NewWriter()
+	for i := 0; i < n; i++ {
+		w.PutWithTTL([]byte(fmt.Sprintf("%010d", i)), -time.Hour)
+	}
+
+	cursor := db.deleteExpired(w, nil)
+	if cursor == nil || string(cursor.Bytes()) != fmt.Sprintf("%010d", expiryScanBatch) {
+		t.Fatalf("Expected the scan to stop after %d items", expiryScanBatch)
+	}
+
+	snap, _ := w.NewSnapshot()
+	if c := snap.Count(); c != 1000 {
+		t.Errorf("Expected count 1000, got %d", c)
+	}
+	snap.Close()
+
+	if cursor = db.deleteExpired(w, cursor); cursor != nil {
+		t.Errorf("Expected the scan to reach the end")
+	}
+
+	snap, _ = w.NewSnapshot()
+	defer snap.Close()
+	if c := snap.Count(); c != 0 {
+		t.Errorf("Expected count 0, got %d", c)
+	}
+}
+
+func TestTTLLoadStoreDisk(t *testing.T) {
+	os.RemoveAll("db.dump")
+	conf := DefaultConfig()
+	db := NewWithConfig(conf)
+
+	n := 1000
+	w := db.NewWriter()
+	for i := 0; i < n; i++ {
+		if i%2 == 0 {
+			w.PutWithTTL([]byte(fmt.Sprintf("%010d", i)), time.Hour)
+		} else {
+			w.Put([]byte(fmt.Sprintf("%010d", i)))
+		}
+	}
+
+	exp := time.Now().Add(time.Hour)
+	snap, _ := w.NewSnapshot()
+	if err := db.StoreToDisk("db.dump", snap, 4, nil); err != nil {
+		t.Errorf("Expected no error. got=%v", err)
+	}
+	db.Close()
+
+	db = NewWithConfig(conf)
+	defer db.Close()
+	snap, err := db.LoadFromDisk("db.dump", 4, nil)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	defer snap.Close()
+
+	i := 0
+	itr := snap.NewIterator()
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		e := (*Item)(itr.GetNode().Item()).Expiry()
+		if i%2 == 0 && (e.Before(exp.Add(-time.Second)) || e.After(exp.Add(time.Second))) {
+			t.Errorf("Unexpected expiry %v for %s", e, itr.Key())
+		} else if i%2 == 1 && !e.IsZero() {
+			t.Errorf("Expected no expiry for %s", itr.Key())
+		}
+		i++
+	}
+	itr.Close()
+
+	if i != n {
+		t.Errorf("Expected %d items, got %d", n, i)
+	}
+}
@@ -0,0 +1,225 @@
+package memdb
+
+import (
+	"fmt"
+	"github.com/t3rm1n4l/memdb/skiplist"
+	"sync/atomic"
+	"unsafe"
+)
+
+var (
+	ErrConflict = fmt.Errorf("Transaction conflicts with a concurrent update")
+	ErrTxnDone  = fmt.Errorf("Transaction has already been committed or rolled back")
+	ErrTxnApply = fmt.Errorf("Transaction writes could not be applied")
+)
+
+type txnWrite struct {
+	key     []byte
+	val     []byte
+	deleted bool
+}
+
+// Txn is an optimistic read-modify-write transaction. Reads are served from
+// the latest snapshot as of Begin and writes are buffered until Commit.
+// Writes made after the latest snapshot are not visible to the reads, but
+// cause the commit to fail with ErrConflict. Commit creates a snapshot, so
+// that a retried transaction observes the result of the failed commit.
+// A Txn should not be used from multiple goroutines at the same time.
+type Txn struct {
+	db     *MemDB
+	snap   *Snapshot
+	reads  map[string]bool
+	writes map[string]int
+	wlist  []txnWrite
+	done   bool
+}
+
+func (m *MemDB) Begin() (*Txn, error) {
+	m.txnOnce.Do(func() {
+		m.txnWriter = m.NewWriter()
+	})
+
+	snap, err := m.openLatestSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Txn{
+		db:     m,
+		snap:   snap,
+		reads:  make(map[string]bool),
+		writes: make(map[string]int),
+	}, nil
+}
+
+// Opens a snapshot of the latest sn without holding off the writers, which
+// are all done with that sn. Like OpenSnapshotAt, snapshot GC is held off
+// until the snapshot is live.
+func (m *MemDB) openLatestSnapshot() (*Snapshot, error) {
+	select {
+	case <-m.gcToken:
+	case <-m.shutdownCh:
+		return nil, ErrShutdown
+	}
+	defer m.GC()
+	defer func() {
+		m.gcToken <- struct{}{}
+	}()
+
+	buf := m.snapshots.MakeBuf()
+	defer m.snapshots.FreeBuf(buf)
+
+	snap := &Snapshot{db: m, sn: m.getCurrSn() - 1, ts: unixNow(), refCount: 1, count: m.ItemsCount(), historical: true}
+	m.snapshots.Insert(unsafe.Pointer(snap), CompareSnapshot, buf, &m.snapshots.Stats)
+	atomic.AddInt64(&m.numSnaps, 1)
+
+	return snap, nil
+}
+
+// Get returns the value of the key as seen by the transaction
+func (t *Txn) Get(bs []byte) ([]byte, bool) {
+	if i, ok := t.writes[string(bs)]; ok {
+		w := t.wlist[i]
+		return w.val, !w.deleted
+	}
+
+	t.reads[string(bs)] = true
+	return t.snap.Get(bs)
+}
+
+func (t *Txn) Put(bs []byte) {
+	t.write(txnWrite{key: bs})
+}
+
+func (t *Txn) PutKV(key, val []byte) {
+	t.write(txnWrite{key: key, val: val})
+}
+
+func (t *Txn) Delete(bs []byte) {
+	t.write(txnWrite{key: bs, deleted: true})
+}
+
+func (t *Txn) write(w txnWrite) {
+	if i, ok := t.writes[string(w.key)]; ok {
+		t.wlist[i] = w
+		return
+	}
+
+	t.writes[string(w.key)] = len(t.wlist)
+	t.wlist = append(t.wlist, w)
+}
+
+// Commit validates that none of the keys read or written by the transaction
+// have been modified after the start snapshot and applies the buffered
+// writes atomically. ErrConflict is returned if validation fails.
+// Like snapshot creation, validation and apply hold off all the writers,
+// hence no concurrent write can slip in between them. ErrTxnApply is
+// returned if any of the buffered writes could not be applied.
+func (t *Txn) Commit() error {
+	if t.done {
+		return ErrTxnDone
+	}
+	defer t.Rollback()
+
+	if len(t.wlist) > 0 {
+		if err := t.db.txnWriter.checkQuota(); err != nil {
+			return err
+		}
+	}
+
+	snap, err := t.commit()
+	if snap != nil {
+		snap.Close()
+	}
+
+	return err
+}
+
+// Validates and applies the writes holding off all the writers. Returns
+// the snapshot created after the writes are applied or the validation
+// fails.
+func (t *Txn) commit() (*Snapshot, error) {
+	m := t.db
+	m.wlock.Lock()
+	defer m.wlock.Unlock()
+
+	for w := m.wlist; w != nil; w = w.next {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+	}
+
+	buf := m.store.MakeBuf()
+	defer m.store.FreeBuf(buf)
+
+	for k := range t.reads {
+		if t.modified([]byte(k), buf) {
+			return t.snapshot(ErrConflict)
+		}
+	}
+
+	for _, w := range t.wlist {
+		if t.modified(w.key, buf) {
+			return t.snapshot(ErrConflict)
+		}
+	}
+
+	if len(t.wlist) == 0 {
+		return nil, nil
+	}
+
+	// Keys are unmodified since the start snapshot, hence deleting a key
+	// absent from it is a no-op
+	b := NewBatch()
+	for _, w := range t.wlist {
+		if !w.deleted {
+			b.UpsertKV(w.key, w.val)
+		} else if _, ok := t.snap.Get(w.key); ok {
+			b.Delete(w.key)
+		}
+	}
+
+	for _, success := range m.txnWriter.apply(b, make([]bool, b.Len())) {
+		if !success {
+			return t.snapshot(ErrTxnApply)
+		}
+	}
+
+	return t.snapshot(nil)
+}
+
+// Called with wlock and the mutex of all the writers held
+func (t *Txn) snapshot(err error) (*Snapshot, error) {
+	snap, snapErr := t.db.newSnapshot()
+	if err == nil {
+		err = snapErr
+	}
+
+	return snap, err
+}
+
+// Rollback discards the buffered writes and releases the start snapshot
+func (t *Txn) Rollback() {
+	if !t.done {
+		t.done = true
+		t.snap.Close()
+	}
+}
+
+// Check if any version of the key was born or has died after the start
+// snapshot
+func (t *Txn) modified(bs []byte, buf *skiplist.ActionBuffer) (found bool) {
+	m := t.db
+	x := m.newItem(bs, nil, false)
+	m.store.Lookup(unsafe.Pointer(x), m.iterCmp, buf, &m.store.Stats,
+		func(n *skiplist.Node) bool {
+			itm := (*Item)(n.Item())
+			deadSn := atomic.LoadUint64(&itm.deadSn)
+			if itm.bornSn > t.snap.sn || deadSn != 0 && deadSn > t.snap.sn {
+				found = true
+				return false
+			}
+			return true
+		})
+
+	return
+}
@@ -0,0 +1,189 @@
+package memdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestTxnConflict(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	w.PutKV([]byte("k1"), []byte("v1"))
+
+	// Writes after the latest snapshot are not visible, but conflict.
+	// The retry observes them.
+	txn0, _ := db.Begin()
+	if _, ok := txn0.Get([]byte("k1")); ok {
+		t.Errorf("Expected k1 to be invisible")
+	}
+	txn0.PutKV([]byte("k1"), []byte("v0"))
+	if err := txn0.Commit(); err != ErrConflict {
+		t.Errorf("Expected conflict, got %v", err)
+	}
+
+	txn1, _ := db.Begin()
+	txn2, _ := db.Begin()
+
+	if v, _ := txn1.Get([]byte("k1")); string(v) != "v1" {
+		t.Errorf("Expected v1, got %s", v)
+	}
+	txn1.PutKV([]byte("k1"), []byte("v2"))
+	if v, _ := txn1.Get([]byte("k1")); string(v) != "v2" {
+		t.Errorf("Expected buffered v2, got %s", v)
+	}
+
+	txn2.Get([]byte("k1"))
+	txn2.Delete([]byte("k1"))
+
+	if err := txn1.Commit(); err != nil {
+		t.Errorf("Expected commit to succeed, got %v", err)
+	}
+
+	if err := txn2.Commit(); err != ErrConflict {
+		t.Errorf("Expected conflict, got %v", err)
+	}
+
+	if err := txn2.Commit(); err != ErrTxnDone {
+		t.Errorf("Expected txn done, got %v", err)
+	}
+
+	// Blind write of a key deleted after the start snapshot
+	txn3, _ := db.Begin()
+	w.Delete([]byte("k1"))
+	txn3.PutKV([]byte("k1"), []byte("v3"))
+	if err := txn3.Commit(); err != ErrConflict {
+		t.Errorf("Expected conflict, got %v", err)
+	}
+
+	txn4, _ := db.Begin()
+	txn4.Delete([]byte("k2"))
+	txn4.PutKV([]byte("k1"), []byte("v4"))
+	if err := txn4.Commit(); err != nil {
+		t.Errorf("Expected commit to succeed, got %v", err)
+	}
+
+	snap, _ := db.NewSnapshot()
+	defer snap.Close()
+	if v, _ := snap.Get([]byte("k1")); string(v) != "v4" {
+		t.Errorf("Expected v4, got %s", v)
+	}
+}
+
+func TestTxnCounters(t *testing.T) {
+	var wg sync.WaitGroup
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	nc, n := 4, 1000
+	incr := func(key []byte) {
+		for {
+			txn, _ := db.Begin()
+			var c uint64
+			if v, ok := txn.Get(key); ok {
+				c = binary.BigEndian.Uint64(v)
+			}
+
+			val := make([]byte, 8)
+			binary.BigEndian.PutUint64(val, c+1)
+			txn.PutKV(key, val)
+			if err := txn.Commit(); err == nil {
+				return
+			} else if err != ErrConflict {
+				t.Errorf("Unexpected error %v", err)
+				return
+			}
+		}
+	}
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				incr([]byte(fmt.Sprintf("counter-%d", (id+j)%nc)))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	snap, _ := db.NewSnapshot()
+	defer snap.Close()
+
+	var total uint64
+	for i := 0; i < nc; i++ {
+		if v, ok := snap.Get([]byte(fmt.Sprintf("counter-%d", i))); ok {
+			total += binary.BigEndian.Uint64(v)
+		}
+	}
+
+	if total != uint64(8*n) {
+		t.Errorf("Expected total %d, got %d", 8*n, total)
+	}
+}
+
+// Commits should not lose increments made through other writers
+func TestTxnConcurrentWriters(t *testing.T) {
+	var wg sync.WaitGroup
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	n := 1000
+	key := []byte("counter")
+	encode := func(c uint64) []byte {
+		val := make([]byte, 8)
+		binary.BigEndian.PutUint64(val, c)
+		return val
+	}
+
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				for {
+					txn, _ := db.Begin()
+					var c uint64
+					if v, ok := txn.Get(key); ok {
+						c = binary.BigEndian.Uint64(v)
+					}
+
+					txn.PutKV(key, encode(c+1))
+					if err := txn.Commit(); err == nil {
+						break
+					} else if err != ErrConflict {
+						t.Errorf("Unexpected error %v", err)
+						return
+					}
+				}
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			w := db.NewWriter()
+			for j := 0; j < n; j++ {
+				for {
+					v, _ := w.PutIfAbsent(key, encode(0))
+					if v == nil {
+						v = encode(0)
+					}
+
+					if w.CompareAndSwap(key, v, encode(binary.BigEndian.Uint64(v)+1)) {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	snap, _ := db.NewSnapshot()
+	defer snap.Close()
+	if v, _ := snap.Get(key); binary.BigEndian.Uint64(v) != uint64(8*n) {
+		t.Errorf("Expected counter %d, got %d", 8*n, binary.BigEndian.Uint64(v))
+	}
+}